@@ -3,6 +3,7 @@ package database
 import (
 	"log"
 	"os"
+	"strings"
 
 	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
 	"gorm.io/driver/sqlite"
@@ -13,13 +14,60 @@ func ConnectDB() {
 	var err error // define error here to prevent overshadowing the global DB
 
 	env := os.Getenv("DATABASE_URL")
-	DB, err = gorm.Open(sqlite.Open(env), &gorm.Config{})
+	DB, err = Open(env)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = DB.AutoMigrate(&entity.User{}, &entity.Transaction{})
+}
+
+// Open opens the SQLite database at path and brings its schema up to date.
+func Open(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(sqliteDSN(path)), &gorm.Config{
+		// report unique index violations as gorm.ErrDuplicatedKey
+		TranslateError: true,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	err = db.AutoMigrate(
+		&entity.User{},
+		&entity.Transaction{},
+		&entity.RefreshToken{},
+		&entity.RevokedToken{},
+		&entity.LoginAttempt{},
+		&entity.OneTimeCode{},
+		&entity.Session{},
+		&entity.UserStatusChange{},
+		&entity.KycSubmission{},
+		&entity.TransactionStatusHistory{},
+		&entity.IdempotencyKey{},
+		&entity.LedgerAccount{},
+		&entity.JournalEntry{},
+		&entity.JournalLeg{},
+		&entity.ScheduledTransfer{},
+		&entity.RecipientLookup{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// sqliteDSN makes concurrent writers wait for each other instead of failing
+// with "database is locked", and makes transactions take the write lock when
+// they begin so that balances read inside one cannot change before it
+// commits.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	busyTimeout := os.Getenv("SQLITE_BUSY_TIMEOUT_MS")
+	if busyTimeout == "" {
+		busyTimeout = "5000"
 	}
 
+	return path + separator + "_busy_timeout=" + busyTimeout + "&_txlock=immediate&_journal_mode=WAL"
 }
@@ -1,6 +1,12 @@
 package database
 
-import "gorm.io/gorm"
+import (
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
 
 // DB gorm connector
 var DB *gorm.DB
+
+// Redis client, nil when REDIS_URL is not configured
+var Redis *redis.Client
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ConnectRedis connects to REDIS_URL when it is set. Redis is optional, so
+// Redis stays nil and callers fall back to the SQL database without it.
+func ConnectRedis() {
+	env := os.Getenv("REDIS_URL")
+	if env == "" {
+		return
+	}
+
+	options, err := redis.ParseURL(env)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Redis = redis.NewClient(options)
+	if err := Redis.Ping(context.Background()).Err(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// IdempotencyKey stores the first response to a request made with an
+// Idempotency-Key header. StatusCode stays 0 while that request is running.
+type IdempotencyKey struct {
+	ID           guuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID       guuid.UUID `gorm:"uniqueIndex:idx_idempotency_keys_user_key" json:"user_id"`
+	Key          string     `gorm:"uniqueIndex:idx_idempotency_keys_user_key" json:"key"`
+	RequestHash  string     `json:"-"`
+	StatusCode   int        `json:"status_code"`
+	ResponseBody []byte     `json:"-"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
+}
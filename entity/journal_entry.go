@@ -0,0 +1,17 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// JournalEntry groups the legs of one posting to the ledger. The amounts of
+// its legs always sum to zero.
+type JournalEntry struct {
+	ID          guuid.UUID `gorm:"primaryKey" json:"id"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+
+	Legs []JournalLeg `json:"legs"`
+}
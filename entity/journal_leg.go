@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// JournalLeg moves Amount into (positive) or out of (negative) an account.
+// TransactionID links the leg to the customer-facing transaction, if any.
+type JournalLeg struct {
+	ID             guuid.UUID  `gorm:"primaryKey" json:"id"`
+	JournalEntryID guuid.UUID  `gorm:"index" json:"journal_entry_id"`
+	AccountID      guuid.UUID  `gorm:"index" json:"account_id"`
+	TransactionID  *guuid.UUID `gorm:"index" json:"transaction_id"`
+	Amount         int64       `json:"amount"`
+	CreatedAt      time.Time   `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// KycSubmission is an identity document sent in for review. The photo is
+// kept in document storage under DocumentKey.
+type KycSubmission struct {
+	ID              guuid.UUID  `gorm:"primaryKey" json:"id"`
+	UserID          guuid.UUID  `gorm:"index" json:"user_id"`
+	DocumentType    string      `json:"document_type"`
+	DocumentNumber  string      `json:"document_number"`
+	FullName        string      `json:"full_name"`
+	DocumentKey     string      `json:"-"`
+	ContentType     string      `json:"content_type"`
+	Status          string      `gorm:"index" json:"status"`
+	ReviewerID      *guuid.UUID `json:"reviewer_id"`
+	RejectionReason string      `json:"rejection_reason"`
+	ReviewedAt      *time.Time  `json:"reviewed_at"`
+	CreatedAt       time.Time   `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time   `gorm:"autoUpdateTime:milli" json:"-"`
+}
@@ -0,0 +1,20 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// LedgerAccount is one account of the double-entry ledger: a user's wallet
+// or a system account such as top-up settlement. Balance is the sum of the
+// account's journal legs, kept up to date as journals are posted.
+type LedgerAccount struct {
+	ID        guuid.UUID  `gorm:"primaryKey" json:"id"`
+	Code      string      `gorm:"uniqueIndex" json:"code"`
+	Type      string      `json:"type"`
+	UserID    *guuid.UUID `gorm:"uniqueIndex" json:"user_id"`
+	Balance   int64       `json:"balance"`
+	CreatedAt time.Time   `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time   `gorm:"autoUpdateTime:milli" json:"-"`
+}
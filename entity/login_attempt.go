@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"time"
+)
+
+// LoginAttempt tracks failed PIN attempts for a throttling key such as a
+// phone number or a client IP.
+type LoginAttempt struct {
+	Key           string     `gorm:"primaryKey" json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
+}
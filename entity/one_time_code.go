@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+type OneTimeCode struct {
+	ID          guuid.UUID `gorm:"primaryKey" json:"id"`
+	PhoneNumber string     `gorm:"index" json:"phone_number"`
+	Purpose     string     `json:"purpose"`
+	CodeHash    string     `json:"-"`
+	Attempts    int        `json:"attempts"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	ConsumedAt  *time.Time `json:"consumed_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
+}
@@ -0,0 +1,15 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// RecipientLookup records a lookup of a transfer recipient so lookups can be
+// rate limited per user.
+type RecipientLookup struct {
+	ID        guuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID    guuid.UUID `gorm:"index" json:"user_id"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
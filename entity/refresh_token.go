@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+type RefreshToken struct {
+	ID           guuid.UUID  `gorm:"primaryKey" json:"id"`
+	UserID       guuid.UUID  `gorm:"index" json:"user_id"`
+	FamilyID     guuid.UUID  `gorm:"index" json:"family_id"`
+	TokenHash    string      `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+	RevokedAt    *time.Time  `json:"revoked_at"`
+	ReplacedByID *guuid.UUID `json:"replaced_by_id"`
+	CreatedAt    time.Time   `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time   `gorm:"autoUpdateTime:milli" json:"-"`
+
+	User User
+}
@@ -0,0 +1,14 @@
+package entity
+
+import (
+	"time"
+)
+
+// RevokedToken is either a single revoked access token, keyed by its jti, or
+// a user-wide revocation with IssuedBefore set.
+type RevokedToken struct {
+	JTI          string     `gorm:"primaryKey" json:"jti"`
+	IssuedBefore *time.Time `json:"issued_before"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
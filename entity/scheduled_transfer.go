@@ -0,0 +1,32 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// ScheduledTransfer is a future-dated or recurring transfer. OccurrenceAt is
+// the occurrence currently due and NextRunAt when it is next attempted,
+// which is later than OccurrenceAt while a failed attempt is being retried.
+type ScheduledTransfer struct {
+	ID           guuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID       guuid.UUID `gorm:"index" json:"user_id"`
+	RecipientID  guuid.UUID `json:"recipient_id"`
+	Amount       int64      `json:"amount"`
+	Remarks      string     `json:"remarks"`
+	Frequency    string     `json:"frequency"`
+	StartAt      time.Time  `json:"start_at"`
+	EndAt        *time.Time `json:"end_at"`
+	Status       string     `json:"status"`
+	Occurrences  int        `json:"occurrences"`
+	OccurrenceAt *time.Time `json:"occurrence_at"`
+	NextRunAt    *time.Time `gorm:"index" json:"next_run_at"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"last_error"`
+	LastRunAt    *time.Time `json:"last_run_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
+
+	Recipient User `json:"-"`
+}
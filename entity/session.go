@@ -0,0 +1,23 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+// Session is one logged-in device. Its ID is also the family id of the
+// refresh tokens issued to that device.
+type Session struct {
+	ID         guuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID     guuid.UUID `gorm:"index" json:"user_id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
+
+	User User `json:"-"`
+}
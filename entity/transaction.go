@@ -7,18 +7,24 @@ import (
 )
 
 type Transaction struct {
-	ID                  guuid.UUID  `gorm:"primaryKey" json:"id"`
-	UserID              guuid.UUID  `json:"user_id"`
-	Type                string      `json:"type"`
-	Category            string      `json:"category"`
-	Amount              int64       `json:"amount"`
-	Remarks             string      `json:"remarks"`
-	Status              string      `json:"status"`
-	BalanceBefore       int64       `json:"balance_before"`
-	BalanceAfter        int64       `json:"balance_after"`
-	CorrespondingUserID *guuid.UUID `json:"corresponding_user_id"`
-	CreatedAt           time.Time   `gorm:"autoCreateTime" json:"created_at" `
-	UpdatedAt           time.Time   `gorm:"autoUpdateTime:milli" json:"-"`
+	ID                    guuid.UUID  `gorm:"primaryKey" json:"id"`
+	UserID                guuid.UUID  `json:"user_id"`
+	Type                  string      `json:"type"`
+	Category              string      `json:"category"`
+	SubCategory           string      `json:"sub_category"`
+	Channel               string      `json:"channel"`
+	Amount                int64       `json:"amount"`
+	Fee                   int64       `json:"fee"`
+	Remarks               string      `json:"remarks"`
+	Status                string      `json:"status"`
+	BalanceBefore         int64       `json:"balance_before"`
+	BalanceAfter          int64       `json:"balance_after"`
+	CorrespondingUserID   *guuid.UUID `json:"corresponding_user_id"`
+	ReferenceID           *guuid.UUID `gorm:"index" json:"reference_id"`
+	OriginalTransactionID *guuid.UUID `gorm:"index" json:"original_transaction_id"`
+	RefundedAmount        int64       `json:"refunded_amount"`
+	CreatedAt             time.Time   `gorm:"autoCreateTime" json:"created_at" `
+	UpdatedAt             time.Time   `gorm:"autoUpdateTime:milli" json:"-"`
 
 	User              User
 	CorrespondingUser User
@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+type TransactionStatusHistory struct {
+	ID            guuid.UUID `gorm:"primaryKey" json:"id"`
+	TransactionID guuid.UUID `gorm:"index" json:"transaction_id"`
+	FromStatus    string     `json:"from_status"`
+	ToStatus      string     `json:"to_status"`
+	Reason        string     `json:"reason"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -6,14 +6,20 @@ import (
 	guuid "github.com/google/uuid"
 )
 
+// User.Balance caches the balance of the user's wallet account in the
+// ledger; it is only changed by posting journals.
 type User struct {
 	ID          guuid.UUID `gorm:"primaryKey" json:"id"`
 	FirstName   string     `json:"first_name"`
 	LastName    string     `json:"last_name"`
 	Address     string     `json:"address"`
 	PhoneNumber string     `json:"phone_number" gorm:"uniqueIndex"`
+	Handle      *string    `json:"handle" gorm:"uniqueIndex"`
 	Pin         string     `json:"-"`
 	Balance     int64      `json:"balance" gorm:"default:0"`
+	Role        string     `json:"role" gorm:"default:customer"`
+	Status      string     `json:"status" gorm:"default:ACTIVE"`
+	KycTier     string     `json:"kyc_tier" gorm:"default:BASIC"`
 	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at" `
 	UpdatedAt   time.Time  `gorm:"autoUpdateTime:milli" json:"-"`
 }
@@ -0,0 +1,17 @@
+package entity
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+type UserStatusChange struct {
+	ID          guuid.UUID `gorm:"primaryKey" json:"id"`
+	UserID      guuid.UUID `gorm:"index" json:"user_id"`
+	FromStatus  string     `json:"from_status"`
+	ToStatus    string     `json:"to_status"`
+	Reason      string     `json:"reason"`
+	ChangedByID guuid.UUID `json:"changed_by_id"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
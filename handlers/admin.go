@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"gorm.io/gorm"
+)
+
+type (
+	UpdateUserRoleRequest struct {
+		Role string `json:"role" validate:"required,oneof=customer support admin"`
+	}
+
+	UnlockIPLoginRequest struct {
+		IP string `json:"ip" validate:"required,ip"`
+	}
+
+	UpdateUserStatusRequest struct {
+		Status string `json:"status" validate:"required,oneof=ACTIVE FROZEN SUSPENDED CLOSED"`
+		Reason string `json:"reason" validate:"required,max=255"`
+	}
+)
+
+func UpdateUserRole(c *fiber.Ctx) error {
+	userUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid User ID",
+		})
+	}
+
+	json := new(UpdateUserRoleRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	user, err := services.UpdateUserRole(userUuid, json.Role)
+	if err == services.ErrInvalidRole {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Role",
+		})
+	}
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	// tokens still carry the old role until they are re-issued
+	if err := services.RevokeAllUserSessions(c.UserContext(), user.ID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": user,
+	})
+}
+
+func UnlockUserLogin(c *fiber.Ctx) error {
+	userUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid User ID",
+		})
+	}
+
+	user, err := services.GetUserByID(userUuid)
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if err := services.UnlockLogin(user.PhoneNumber); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
+
+// UnlockIPLogin lifts a lockout on a client IP. Unlocking a user only clears
+// their phone number, so an IP that is locked as well needs this too.
+func UnlockIPLogin(c *fiber.Ctx) error {
+	json := new(UnlockIPLoginRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	if err := services.UnlockLoginIP(json.IP); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
+
+func UpdateUserStatus(c *fiber.Ctx) error {
+	adminUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	userUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid User ID",
+		})
+	}
+
+	json := new(UpdateUserStatusRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	user, err := services.ChangeUserStatus(c.UserContext(), userUuid, json.Status, json.Reason, adminUuid)
+	if err == services.ErrInvalidUserStatus || err == services.ErrInvalidUserStatusTransition ||
+		err == services.ErrStatusReasonRequired || err == services.ErrAccountHasBalance {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": user,
+	})
+}
+
+func GetUserStatusHistory(c *fiber.Ctx) error {
+	userUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid User ID",
+		})
+	}
+
+	history, err := services.GetUserStatusHistory(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": history,
+	})
+}
@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
-	"os"
-	"time"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	jwt "github.com/golang-jwt/jwt/v5"
@@ -14,11 +14,16 @@ import (
 
 type (
 	RegisterRequest struct {
-		FirstName   string `json:"first_name" validate:"required"`
-		LastName    string `json:"last_name" validate:"required"`
-		PhoneNumber string `json:"phone_number" validate:"required"`
-		Address     string `json:"address" validate:"required"`
-		Pin         string `json:"pin" validate:"required"`
+		FirstName   string `json:"first_name" validate:"required,max=100"`
+		LastName    string `json:"last_name" validate:"required,max=100"`
+		PhoneNumber string `json:"phone_number" validate:"required,phone"`
+		Address     string `json:"address" validate:"required,max=255"`
+		Pin         string `json:"pin" validate:"required,pin"`
+		OtpCode     string `json:"otp_code" validate:"required,len=6,numeric"`
+	}
+
+	RegisterOtpRequest struct {
+		PhoneNumber string `json:"phone_number" validate:"required,phone"`
 	}
 
 	RegisterResponse struct {
@@ -33,6 +38,7 @@ type (
 	LoginRequest struct {
 		PhoneNumber string `json:"phone_number" validate:"required"`
 		Pin         string `json:"pin" validate:"required"`
+		DeviceName  string `json:"device_name" validate:"max=100"`
 	}
 
 	LoginResponse struct {
@@ -40,10 +46,19 @@ type (
 		RefreshToken string `json:"refresh_token"`
 	}
 
+	RefreshRequest struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	LogoutRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	UpdateProfileRequest struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Address   string `json:"address"`
+		FirstName string `json:"first_name" validate:"max=100"`
+		LastName  string `json:"last_name" validate:"max=100"`
+		Address   string `json:"address" validate:"max=255"`
+		Handle    string `json:"handle" validate:"omitempty,handle"`
 	}
 
 	UpdateProfileResponse struct {
@@ -56,14 +71,45 @@ type (
 	}
 )
 
-func Register(c *fiber.Ctx) error {
-	json := new(RegisterRequest)
-	if err := c.BodyParser(json); err != nil {
+func RequestRegisterOtp(c *fiber.Ctx) error {
+	json := new(RegisterOtpRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	_, err := services.GetUserByPhoneNumber(json.PhoneNumber)
+	if err != gorm.ErrRecordNotFound {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
+			"message": "Phone Number already registered",
 		})
 	}
 
+	err = services.SendOneTimeCode(c.UserContext(), json.PhoneNumber, services.OneTimeCodePurposeRegister)
+	if throttled, ok := err.(*services.OneTimeCodeThrottledError); ok {
+		retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+			"message":     "OTP was requested too often, please wait before trying again",
+			"retry_after": retryAfter,
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
+
+func Register(c *fiber.Ctx) error {
+	json := new(RegisterRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
 	_, err := services.GetUserByPhoneNumber(json.PhoneNumber)
 	if err != gorm.ErrRecordNotFound {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
@@ -71,6 +117,18 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
+	err = services.VerifyOneTimeCode(json.PhoneNumber, services.OneTimeCodePurposeRegister, json.OtpCode)
+	if err == services.ErrOneTimeCodeInvalid || err == services.ErrOneTimeCodeExpired {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid or expired OTP code",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
 	newUser, err := services.CreateUser(&services.User{
 		FirstName:   json.FirstName,
 		LastName:    json.LastName,
@@ -100,36 +158,132 @@ func Register(c *fiber.Ctx) error {
 
 func Login(c *fiber.Ctx) error {
 	json := new(LoginRequest)
-	if err := c.BodyParser(json); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	err := services.CheckLoginAllowed(json.PhoneNumber, c.IP())
+	if blocked, ok := err.(*services.LoginBlockedError); ok {
+		return loginBlockedResponse(c, blocked)
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
 		})
 	}
 
 	user, err := services.GetUserByPhoneNumber(json.PhoneNumber)
-	if err == gorm.ErrRecordNotFound {
+	if err == nil {
+		err = services.CompareHash(user.Pin, json.Pin)
+	}
+	if err != nil {
+		if err := services.RecordLoginFailure(json.PhoneNumber, c.IP()); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"message": "Phone Number and PIN doesn't match",
 		})
 	}
 
-	err = services.CompareHash(user.Pin, json.Pin)
+	if err := services.ResetLoginFailures(json.PhoneNumber); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if err := services.CheckCanLogin(user); err != nil {
+		return accountStatusErrorResponse(c, err)
+	}
+
+	session, err := services.CreateSession(user.ID, json.DeviceName, c.Get(fiber.HeaderUserAgent), c.IP())
 	if err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Phone Number and PIN doesn't match",
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
 		})
 	}
 
-	jwtClaims := jwt.MapClaims{
-		"iss":     "technical-test-fm-tahap-2",
-		"iat":     time.Now().Unix(),
-		"exp":     time.Now().Add(time.Minute * 15).Unix(),
-		"user_id": user.ID.String(),
+	accessToken, err := services.IssueAccessToken(user, session.ID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": err.Error(),
+		})
 	}
 
-	jwtSecretKey := os.Getenv("JWT_SECRET_KEY")
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims)
-	accessToken, err := token.SignedString([]byte(jwtSecretKey))
+	refreshToken, err := services.IssueRefreshToken(user.ID, session.ID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": LoginResponse{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		},
+	})
+}
+
+func loginBlockedResponse(c *fiber.Ctx, blocked *services.LoginBlockedError) error {
+	retryAfter := int(math.Ceil(blocked.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	if blocked.Locked {
+		return c.Status(http.StatusLocked).JSON(fiber.Map{
+			"code":        "ACCOUNT_LOCKED",
+			"message":     "Too many failed attempts, account is temporarily locked",
+			"retry_after": retryAfter,
+		})
+	}
+
+	return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+		"code":        "TOO_MANY_ATTEMPTS",
+		"message":     "Too many failed attempts, please wait before trying again",
+		"retry_after": retryAfter,
+	})
+}
+
+func Refresh(c *fiber.Ctx) error {
+	json := new(RefreshRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	issued, refreshToken, err := services.RotateRefreshToken(json.RefreshToken)
+	if err == services.ErrRefreshTokenInvalid || err == services.ErrRefreshTokenExpired || err == services.ErrRefreshTokenReused {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	user, err := services.GetUserByID(issued.UserID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if err := services.CheckCanLogin(user); err != nil {
+		return accountStatusErrorResponse(c, err)
+	}
+
+	// refresh tokens of a session share its id as their family id
+	if err := services.TouchSession(issued.FamilyID, c.IP()); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	accessToken, err := services.IssueAccessToken(user, issued.FamilyID)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"message": err.Error(),
@@ -140,17 +294,75 @@ func Login(c *fiber.Ctx) error {
 		"status": "SUCCESS",
 		"result": LoginResponse{
 			AccessToken:  accessToken,
-			RefreshToken: "refresh_token",
+			RefreshToken: refreshToken,
 		},
 	})
 }
 
+func Logout(c *fiber.Ctx) error {
+	json := new(LogoutRequest)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(json); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid JSON",
+			})
+		}
+	}
+
+	jwtClaims := c.Locals("userInfo").(jwt.MapClaims)
+	expiresAt, err := jwtClaims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
+
+	jti := jwtClaims["jti"].(string)
+	err = services.TokenRevocations.Revoke(c.UserContext(), jti, expiresAt.Time)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	sessionUuid, err := extractSessionUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	err = services.RevokeSession(userUuid, sessionUuid)
+	if err != nil && err != services.ErrSessionNotFound {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if json.RefreshToken != "" {
+		if err := services.RevokeRefreshToken(json.RefreshToken); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
+
 func UpdateProfile(c *fiber.Ctx) error {
 	json := new(UpdateProfileRequest)
-	if err := c.BodyParser(json); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
-		})
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
 	}
 
 	jwtClaims := c.Locals("userInfo").(jwt.MapClaims)
@@ -168,8 +380,16 @@ func UpdateProfile(c *fiber.Ctx) error {
 		LastName:  json.LastName,
 		Address:   json.Address,
 	}
+	if json.Handle != "" {
+		updateRequest.Handle = &json.Handle
+	}
 
 	updatedUser, err := services.UpdateUser(&updateRequest)
+	if err == services.ErrHandleTaken {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Internal Server Error",
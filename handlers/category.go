@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+)
+
+type (
+	UpdateTransactionCategoryRequest struct {
+		SubCategory string `json:"sub_category" validate:"max=50"`
+	}
+
+	SpendingSummaryQuery struct {
+		Month string `query:"month" json:"month" validate:"omitempty,datetime=2006-01"`
+	}
+
+	CategorySpendingResponse struct {
+		Category         string `json:"category"`
+		SubCategory      string `json:"sub_category"`
+		TotalAmount      int64  `json:"total_amount"`
+		TransactionCount int64  `json:"transaction_count"`
+	}
+
+	SpendingSummaryResponse struct {
+		Month       string                     `json:"month"`
+		TotalAmount int64                      `json:"total_amount"`
+		Categories  []CategorySpendingResponse `json:"categories"`
+	}
+)
+
+func GetCategories(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": services.TransactionCategories,
+	})
+}
+
+// UpdateTransactionCategory lets users file one of their payments under a
+// sub-category.
+func UpdateTransactionCategory(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	transactionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Transaction ID",
+		})
+	}
+
+	json := new(UpdateTransactionCategoryRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	transaction, err := services.SetTransactionSubCategory(userUuid, transactionUuid, json.SubCategory)
+	if err == services.ErrTransactionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Transaction not found",
+		})
+	}
+	if err == services.ErrSubCategoryNotAllowed || err == services.ErrInvalidSubCategory {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newTransactionResponse(transaction),
+	})
+}
+
+// GetSpendingSummary totals the user's spending per category for a month
+// (?month=2006-01), the current month by default.
+func GetSpendingSummary(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	query := new(SpendingSummaryQuery)
+	if err := c.QueryParser(query); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Query",
+		})
+	}
+	if err := validation.Struct(query); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	if query.Month != "" {
+		monthStart, _ = time.ParseInLocation("2006-01", query.Month, time.Local)
+	}
+
+	summary, err := services.GetSpendingSummary(userUuid, monthStart)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := SpendingSummaryResponse{
+		Month:      monthStart.Format("2006-01"),
+		Categories: []CategorySpendingResponse{},
+	}
+	for _, spending := range summary {
+		result.TotalAmount += spending.TotalAmount
+		result.Categories = append(result.Categories, CategorySpendingResponse{
+			Category:         spending.Category,
+			SubCategory:      spending.SubCategory,
+			TotalAmount:      spending.TotalAmount,
+			TransactionCount: spending.TransactionCount,
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
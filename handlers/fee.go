@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+)
+
+type (
+	FeeQuoteQuery struct {
+		Category string `query:"category" json:"category" validate:"required,oneof=TopUp Payment Transfer"`
+		Channel  string `query:"channel" json:"channel" validate:"omitempty,oneof=APP BANK_TRANSFER VIRTUAL_ACCOUNT CARD RETAIL"`
+		Amount   int64  `query:"amount" json:"amount" validate:"required,gt=0"`
+	}
+
+	FeeQuoteResponse struct {
+		Category string `json:"category"`
+		Channel  string `json:"channel"`
+		Amount   int64  `json:"amount"`
+		Fee      int64  `json:"fee"`
+		// WalletChange is what the transaction and its fee do to the balance
+		WalletChange int64 `json:"wallet_change"`
+	}
+)
+
+func GetFeeRules(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": services.FeeRules(),
+	})
+}
+
+// QuoteFee tells the user what a top-up, payment or transfer will cost
+// before they make it. The channel defaults to the one the matching endpoint
+// uses.
+func QuoteFee(c *fiber.Ctx) error {
+	query := new(FeeQuoteQuery)
+	if err := c.QueryParser(query); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Query",
+		})
+	}
+	if err := validation.Struct(query); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if query.Channel == "" {
+		query.Channel = services.ChannelApp
+		if query.Category == services.CategoryTopUp {
+			query.Channel = services.ChannelBankTransfer
+		}
+	}
+
+	quote, err := services.QuoteFee(query.Category, query.Channel, query.Amount)
+	if err != nil {
+		return transactionErrorResponse(c, err)
+	}
+
+	walletChange := -(quote.Amount + quote.Fee)
+	if quote.Category == services.CategoryTopUp {
+		walletChange = quote.Amount - quote.Fee
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": &FeeQuoteResponse{
+			Category:     quote.Category,
+			Channel:      quote.Channel,
+			Amount:       quote.Amount,
+			Fee:          quote.Fee,
+			WalletChange: walletChange,
+		},
+	})
+}
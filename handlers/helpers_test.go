@@ -0,0 +1,116 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/router"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+// newTestApp serves the real routes on a fresh SQLite file, signing tokens
+// with an ephemeral key.
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("APP_ENV", "development")
+	if err := services.LoadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	router.Initalize(app)
+	return app
+}
+
+// createTestUser registers a user with a logged in session and returns the
+// user with an access token for it.
+func createTestUser(t *testing.T, phoneNumber string, firstName string) (*services.User, string) {
+	t.Helper()
+
+	created, err := services.CreateUser(&services.User{FirstName: firstName, LastName: "Doe", PhoneNumber: phoneNumber, Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := services.GetUserByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := services.CreateSession(user.ID, "test", "test", "0.0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := services.IssueAccessToken(user, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, accessToken
+}
+
+func fundTestUser(t *testing.T, user *services.User, amount int64) {
+	t.Helper()
+
+	_, err := services.CreateCreditTransaction(user.ID, services.NewTransactionRequest{
+		UserID:   user.ID,
+		Amount:   amount,
+		Category: services.CategoryTopUp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// doRequest sends a request with the access token and returns the status
+// and the decoded JSON body.
+func doRequest(t *testing.T, app *fiber.App, method string, path string, accessToken string, body interface{}, headers map[string]string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(content))
+	request.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	decoded := map[string]interface{}{}
+	json.NewDecoder(response.Body).Decode(&decoded)
+	return response, decoded
+}
@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/middleware"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+func TestIdempotencyReplaysTheStoredResponse(t *testing.T) {
+	app := newTestApp(t)
+	user, accessToken := createTestUser(t, "081200000001", "Alice")
+	headers := map[string]string{"Idempotency-Key": "top-up-1"}
+	body := map[string]interface{}{"amount": 10000}
+
+	first, firstResult := doRequest(t, app, http.MethodPost, "/topup", accessToken, body, headers)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("top up returned %d %v, want %d", first.StatusCode, firstResult, http.StatusOK)
+	}
+
+	second, secondResult := doRequest(t, app, http.MethodPost, "/topup", accessToken, body, headers)
+	if second.StatusCode != http.StatusOK || second.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry returned %d replayed=%q, want %d replayed", second.StatusCode, second.Header.Get("Idempotent-Replayed"), http.StatusOK)
+	}
+	firstTopUp := firstResult["result"].(map[string]interface{})
+	secondTopUp := secondResult["result"].(map[string]interface{})
+	if firstTopUp["top_up_id"] != secondTopUp["top_up_id"] {
+		t.Errorf("retry returned top up %v, want %v", secondTopUp["top_up_id"], firstTopUp["top_up_id"])
+	}
+
+	var count int64
+	database.DB.Model(&services.Transaction{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("%d transactions were created, want 1", count)
+	}
+}
+
+func TestIdempotencyKeyWithAnotherBody(t *testing.T) {
+	app := newTestApp(t)
+	_, accessToken := createTestUser(t, "081200000001", "Alice")
+	headers := map[string]string{"Idempotency-Key": "top-up-1"}
+
+	response, result := doRequest(t, app, http.MethodPost, "/topup", accessToken, map[string]interface{}{"amount": 10000}, headers)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("top up returned %d %v, want %d", response.StatusCode, result, http.StatusOK)
+	}
+
+	response, _ = doRequest(t, app, http.MethodPost, "/topup", accessToken, map[string]interface{}{"amount": 20000}, headers)
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("reusing the key for another body returned %d, want %d", response.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestIdempotencyLetsARetryThroughAfterStepUp(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("STEP_UP_AMOUNT_THRESHOLD", "50000")
+	user, accessToken := createTestUser(t, "081200000001", "Alice")
+	fundTestUser(t, user, 100000)
+	body := map[string]interface{}{"amount": 60000, "remarks": "rent"}
+
+	response, result := doRequest(t, app, http.MethodPost, "/payment", accessToken, body, map[string]string{"Idempotency-Key": "payment-1"})
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("payment without a transaction token returned %d %v, want %d", response.StatusCode, result, http.StatusForbidden)
+	}
+
+	transactionToken := verifyPin(t, app, accessToken, map[string]interface{}{
+		"pin": "123456", "category": "Payment", "amount": 60000,
+	})
+	headers := map[string]string{"Idempotency-Key": "payment-1", "X-Transaction-Token": transactionToken}
+	response, result = doRequest(t, app, http.MethodPost, "/payment", accessToken, body, headers)
+	if response.StatusCode != http.StatusOK || response.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("payment after step-up returned %d %v, want a fresh %d", response.StatusCode, result, http.StatusOK)
+	}
+}
+
+func TestIdempotencySkipsResponsesThatAskForAnotherTry(t *testing.T) {
+	tests := []struct {
+		status   int
+		replayed bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusLocked, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			// only the database of the test app is used
+			newTestApp(t)
+			userId := uuid.New()
+
+			calls := 0
+			app := fiber.New()
+			app.Post("/", func(c *fiber.Ctx) error {
+				c.Locals("userInfo", jwt.MapClaims{"user_id": userId.String()})
+				return c.Next()
+			}, middleware.Idempotency, func(c *fiber.Ctx) error {
+				calls++
+				if calls == 1 {
+					return c.Status(test.status).JSON(fiber.Map{"calls": calls})
+				}
+				return c.Status(http.StatusOK).JSON(fiber.Map{"calls": calls})
+			})
+
+			headers := map[string]string{"Idempotency-Key": "key-1"}
+			doRequest(t, app, http.MethodPost, "/", "", nil, headers)
+			response, result := doRequest(t, app, http.MethodPost, "/", "", nil, headers)
+
+			replayed := response.Header.Get("Idempotent-Replayed") == "true"
+			if replayed != test.replayed {
+				t.Fatalf("retry replayed=%v, want %v", replayed, test.replayed)
+			}
+			if test.replayed {
+				if response.StatusCode != test.status || result["calls"] != float64(1) || calls != 1 {
+					t.Errorf("retry returned %d %v after %d calls, want the stored %d", response.StatusCode, result, calls, test.status)
+				}
+				return
+			}
+			if response.StatusCode != http.StatusOK || calls != 2 {
+				t.Errorf("retry returned %d after %d calls, want %d from a second call", response.StatusCode, calls, http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+// JWKS publishes the public keys so other services can verify our tokens.
+func JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(http.StatusOK).JSON(services.SigningKeys.JWKS())
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"gorm.io/gorm"
+)
+
+const maxKycDocumentSize = 2 << 20
+
+type (
+	SubmitKycDocumentRequest struct {
+		DocumentType   string `form:"document_type" json:"document_type" validate:"required,oneof=KTP PASSPORT"`
+		DocumentNumber string `form:"document_number" json:"document_number" validate:"required,max=50"`
+		FullName       string `form:"full_name" json:"full_name" validate:"required,max=200"`
+	}
+
+	RejectKycSubmissionRequest struct {
+		Reason string `json:"reason" validate:"required,max=255"`
+	}
+
+	KycSubmissionResponse struct {
+		SubmissionID    string `json:"submission_id"`
+		UserID          string `json:"user_id"`
+		DocumentType    string `json:"document_type"`
+		DocumentNumber  string `json:"document_number"`
+		FullName        string `json:"full_name"`
+		Status          string `json:"status"`
+		RejectionReason string `json:"rejection_reason"`
+		ReviewedDate    string `json:"reviewed_date"`
+		CreatedDate     string `json:"created_date"`
+	}
+
+	KycStatusResponse struct {
+		Tier        string                  `json:"tier"`
+		Caps        services.KycTierCap     `json:"caps"`
+		Submissions []KycSubmissionResponse `json:"submissions"`
+	}
+)
+
+// GetKycStatus shows the user's verification tier, what the tier allows and
+// the documents the user has submitted.
+func GetKycStatus(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	user, err := services.GetUserByID(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	submissions, err := services.GetKycSubmissions(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": &KycStatusResponse{
+			Tier:        user.KycTier,
+			Caps:        services.KycTierCaps(user.KycTier),
+			Submissions: newKycSubmissionResponses(submissions),
+		},
+	})
+}
+
+// SubmitKycDocument takes a multipart form with the document details and
+// the photo of the document in the "document" field.
+func SubmitKycDocument(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	form := new(SubmitKycDocumentRequest)
+	if ok, err := parseAndValidate(c, form); !ok {
+		return err
+	}
+
+	header, err := c.FormFile("document")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Document file is required",
+		})
+	}
+	if header.Size > maxKycDocumentSize {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"message": "Document file must be at most 2 MB",
+		})
+	}
+
+	document, err := header.Open()
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Document file is invalid",
+		})
+	}
+	defer document.Close()
+
+	request := services.NewKycSubmissionRequest{
+		DocumentType:   form.DocumentType,
+		DocumentNumber: form.DocumentNumber,
+		FullName:       form.FullName,
+	}
+	submission, err := services.SubmitKycDocument(c.UserContext(), userUuid, request, document)
+	if err == services.ErrKycDocumentType {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err == services.ErrKycAlreadyVerified || err == services.ErrKycSubmissionPending {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newKycSubmissionResponse(submission),
+	})
+}
+
+func GetKycSubmissionsForReview(c *fiber.Ctx) error {
+	status := c.Query("status")
+	if status != "" && status != services.KycSubmissionStatusPending &&
+		status != services.KycSubmissionStatusApproved && status != services.KycSubmissionStatusRejected {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Status",
+		})
+	}
+
+	submissions, err := services.GetKycSubmissionsForReview(status)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newKycSubmissionResponses(submissions),
+	})
+}
+
+// GetKycDocument sends the uploaded document of a submission to a reviewer.
+func GetKycDocument(c *fiber.Ctx) error {
+	submissionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Submission ID",
+		})
+	}
+
+	submission, document, err := services.OpenKycDocument(c.UserContext(), submissionUuid)
+	if err == services.ErrKycSubmissionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	// identity documents must not end up in shared caches
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, submission.ContentType)
+	return c.Status(http.StatusOK).SendStream(document)
+}
+
+func ApproveKycSubmission(c *fiber.Ctx) error {
+	return reviewKycSubmission(c, func(submissionUuid uuid.UUID, reviewerUuid uuid.UUID) (*services.KycSubmission, error) {
+		return services.ApproveKycSubmission(submissionUuid, reviewerUuid)
+	})
+}
+
+func RejectKycSubmission(c *fiber.Ctx) error {
+	json := new(RejectKycSubmissionRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	return reviewKycSubmission(c, func(submissionUuid uuid.UUID, reviewerUuid uuid.UUID) (*services.KycSubmission, error) {
+		return services.RejectKycSubmission(submissionUuid, reviewerUuid, json.Reason)
+	})
+}
+
+func reviewKycSubmission(c *fiber.Ctx, review func(uuid.UUID, uuid.UUID) (*services.KycSubmission, error)) error {
+	reviewerUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	submissionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Submission ID",
+		})
+	}
+
+	submission, err := review(submissionUuid, reviewerUuid)
+	if err == services.ErrKycRejectionReason {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err == services.ErrKycSubmissionNotFound || err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "KYC submission not found",
+		})
+	}
+	if err == services.ErrKycSubmissionReviewed {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newKycSubmissionResponse(submission),
+	})
+}
+
+func newKycSubmissionResponses(submissions []services.KycSubmission) []KycSubmissionResponse {
+	result := []KycSubmissionResponse{}
+	for i := range submissions {
+		result = append(result, newKycSubmissionResponse(&submissions[i]))
+	}
+	return result
+}
+
+func newKycSubmissionResponse(submission *services.KycSubmission) KycSubmissionResponse {
+	return KycSubmissionResponse{
+		SubmissionID:    submission.ID.String(),
+		UserID:          submission.UserID.String(),
+		DocumentType:    submission.DocumentType,
+		DocumentNumber:  submission.DocumentNumber,
+		FullName:        submission.FullName,
+		Status:          submission.Status,
+		RejectionReason: submission.RejectionReason,
+		ReviewedDate:    formatOptionalTime(submission.ReviewedAt),
+		CreatedDate:     submission.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
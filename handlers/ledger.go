@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type (
+	LedgerAccountResponse struct {
+		Code    string `json:"code"`
+		Balance int64  `json:"balance"`
+	}
+
+	LedgerReportResponse struct {
+		Balanced           bool     `json:"balanced"`
+		UnbalancedEntries  []string `json:"unbalanced_entries"`
+		MismatchedAccounts []string `json:"mismatched_accounts"`
+		MismatchedUsers    []string `json:"mismatched_users"`
+	}
+)
+
+func GetLedgerAccounts(c *fiber.Ctx) error {
+	accounts, err := services.GetSystemLedgerAccounts()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := []LedgerAccountResponse{}
+	for _, account := range accounts {
+		result = append(result, LedgerAccountResponse{
+			Code:    account.Code,
+			Balance: account.Balance,
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
+
+// VerifyLedger reports whether the books balance.
+func VerifyLedger(c *fiber.Ctx) error {
+	report, err := services.VerifyLedger()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := LedgerReportResponse{
+		Balanced:           report.Balanced,
+		UnbalancedEntries:  []string{},
+		MismatchedAccounts: report.MismatchedAccounts,
+		MismatchedUsers:    []string{},
+	}
+	for _, entryId := range report.UnbalancedEntries {
+		result.UnbalancedEntries = append(result.UnbalancedEntries, entryId.String())
+	}
+	for _, userId := range report.MismatchedUsers {
+		result.MismatchedUsers = append(result.MismatchedUsers, userId.String())
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
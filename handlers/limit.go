@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type LimitResponse struct {
+	Category         string `json:"category"`
+	Type             string `json:"type"`
+	PerTransaction   int64  `json:"per_transaction"`
+	Daily            int64  `json:"daily"`
+	Monthly          int64  `json:"monthly"`
+	UsedToday        int64  `json:"used_today"`
+	UsedThisMonth    int64  `json:"used_this_month"`
+	RemainingToday   *int64 `json:"remaining_today"`
+	RemainingMonthly *int64 `json:"remaining_monthly"`
+	DailyResetAt     string `json:"daily_reset_at"`
+	MonthlyResetAt   string `json:"monthly_reset_at"`
+}
+
+// GetLimits shows the user's transaction limits and how much of the daily
+// and monthly allowance is left. A limit of 0 and a null remaining amount
+// mean unlimited.
+func GetLimits(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	usages, err := services.GetLimitUsage(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := []LimitResponse{}
+	for _, usage := range usages {
+		result = append(result, LimitResponse{
+			Category:         usage.Category,
+			Type:             usage.Type,
+			PerTransaction:   usage.PerTransaction,
+			Daily:            usage.Daily,
+			Monthly:          usage.Monthly,
+			UsedToday:        usage.UsedToday,
+			UsedThisMonth:    usage.UsedThisMonth,
+			RemainingToday:   usage.RemainingToday,
+			RemainingMonthly: usage.RemainingMonthly,
+			DailyResetAt:     usage.DailyResetAt.Format("2006-01-02 15:04:05 -07:00"),
+			MonthlyResetAt:   usage.MonthlyResetAt.Format("2006-01-02 15:04:05 -07:00"),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"gorm.io/gorm"
+)
+
+type (
+	ChangePinRequest struct {
+		OldPin string `json:"old_pin" validate:"required"`
+		NewPin string `json:"new_pin" validate:"required,pin,nefield=OldPin"`
+	}
+
+	ForgotPinRequest struct {
+		PhoneNumber string `json:"phone_number" validate:"required,phone"`
+	}
+
+	ResetPinRequest struct {
+		PhoneNumber string `json:"phone_number" validate:"required,phone"`
+		Code        string `json:"code" validate:"required,len=6,numeric"`
+		NewPin      string `json:"new_pin" validate:"required,pin"`
+	}
+
+	// VerifyPinRequest describes the payment or transfer the token will be
+	// used for; the token covers amounts up to Amount.
+	VerifyPinRequest struct {
+		Pin        string `json:"pin" validate:"required"`
+		Category   string `json:"category" validate:"required,oneof=Payment Transfer"`
+		Amount     int64  `json:"amount" validate:"required,gt=0"`
+		TargetUser string `json:"target_user" validate:"required_if=Category Transfer,max=255"`
+	}
+
+	VerifyPinResponse struct {
+		TransactionToken string `json:"transaction_token"`
+		ExpiresAt        string `json:"expires_at"`
+	}
+)
+
+func ChangePin(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	json := new(ChangePinRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	user, err := services.GetUserByID(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	err = services.VerifyPin(user, json.OldPin, c.IP())
+	if blocked, ok := err.(*services.LoginBlockedError); ok {
+		return loginBlockedResponse(c, blocked)
+	}
+	if err == services.ErrPinMismatch {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Old PIN doesn't match",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return updatePinAndRevokeSessions(c, user, json.NewPin)
+}
+
+// VerifyPin confirms the PIN and returns a single-use transaction token that
+// satisfies the step-up check of one payment or transfer like the one
+// described in the request.
+func VerifyPin(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	sessionUuid, err := extractSessionUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	json := new(VerifyPinRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	user, err := services.GetUserByID(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	err = services.VerifyPin(user, json.Pin, c.IP())
+	if blocked, ok := err.(*services.LoginBlockedError); ok {
+		return loginBlockedResponse(c, blocked)
+	}
+	if err == services.ErrPinMismatch {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "PIN doesn't match",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	scope := services.TransactionScope{Category: json.Category, Amount: json.Amount}
+	if json.Category == services.CategoryTransfer {
+		recipient, err := services.LookUpRecipient(userUuid, json.TargetUser)
+		if err != nil {
+			return transactionErrorResponse(c, err)
+		}
+		scope.RecipientID = &recipient.ID
+	}
+
+	transactionToken, expiresAt, err := services.IssueTransactionToken(userUuid, sessionUuid, scope)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": VerifyPinResponse{
+			TransactionToken: transactionToken,
+			ExpiresAt:        expiresAt.Format("2006-01-02 15:04:05"),
+		},
+	})
+}
+
+func ForgotPin(c *fiber.Ctx) error {
+	json := new(ForgotPinRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	// respond the same way for unknown numbers so this can't be used to
+	// discover registered phone numbers
+	_, err := services.GetUserByPhoneNumber(json.PhoneNumber)
+	if err == nil {
+		err = services.SendOneTimeCode(c.UserContext(), json.PhoneNumber, services.OneTimeCodePurposeResetPin)
+	}
+	// throttling is not reported either, for the same reason
+	if _, ok := err.(*services.OneTimeCodeThrottledError); ok {
+		err = nil
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
+
+func ResetPin(c *fiber.Ctx) error {
+	json := new(ResetPinRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	err := services.VerifyOneTimeCode(json.PhoneNumber, services.OneTimeCodePurposeResetPin, json.Code)
+	if err == services.ErrOneTimeCodeInvalid || err == services.ErrOneTimeCodeExpired {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid or expired code",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	user, err := services.GetUserByPhoneNumber(json.PhoneNumber)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	// proving ownership of the phone number also lifts a PIN lockout
+	if err := services.UnlockLogin(json.PhoneNumber); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return updatePinAndRevokeSessions(c, user, json.NewPin)
+}
+
+func updatePinAndRevokeSessions(c *fiber.Ctx, user *services.User, newPin string) error {
+	if err := services.UpdateUserPin(user.ID, newPin); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if err := services.RevokeAllUserSessions(c.UserContext(), user.ID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
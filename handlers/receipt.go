@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type (
+	ReceiptResponse struct {
+		ReceiptNumber string `json:"receipt_number"`
+		Title         string `json:"title"`
+		Date          string `json:"date"`
+		Type          string `json:"type"`
+		Category      string `json:"category"`
+		Amount        string `json:"amount"`
+		Status        string `json:"status"`
+		From          string `json:"from"`
+		To            string `json:"to"`
+		Remarks       string `json:"remarks"`
+	}
+)
+
+var receiptTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}} {{.ReceiptNumber}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Receipt No.</th><td>{{.ReceiptNumber}}</td></tr>
+<tr><th>Date</th><td>{{.Date}}</td></tr>
+<tr><th>Type</th><td>{{.Type}}</td></tr>
+<tr><th>Category</th><td>{{.Category}}</td></tr>
+<tr><th>Amount</th><td>{{.Amount}}</td></tr>
+<tr><th>Status</th><td>{{.Status}}</td></tr>
+<tr><th>From</th><td>{{.From}}</td></tr>
+<tr><th>To</th><td>{{.To}}</td></tr>
+<tr><th>Remarks</th><td>{{.Remarks}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// GetTransactionReceipt renders a shareable receipt of one of the user's
+// transactions as JSON (default), plain text (?format=text) or HTML
+// (?format=html).
+func GetTransactionReceipt(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	transactionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Transaction ID",
+		})
+	}
+
+	format := c.Query("format", "json")
+	if format != "json" && format != "text" && format != "html" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Format",
+		})
+	}
+
+	transaction, _, err := services.GetUserTransaction(userUuid, transactionUuid)
+	if err == services.ErrTransactionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Transaction not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	receipt := newReceiptResponse(transaction)
+
+	if format == "text" {
+		c.Type("txt", "utf-8")
+		return c.Status(http.StatusOK).SendString(receiptText(receipt))
+	}
+
+	if format == "html" {
+		var body bytes.Buffer
+		if err := receiptTemplate.Execute(&body, receipt); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
+		c.Type("html", "utf-8")
+		return c.Status(http.StatusOK).Send(body.Bytes())
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": receipt,
+	})
+}
+
+func newReceiptResponse(transaction *services.Transaction) ReceiptResponse {
+	owner := strings.TrimSpace(transaction.User.FirstName + " " + transaction.User.LastName)
+	counterparty := ""
+	if transaction.CorrespondingUserID != nil {
+		counterparty = strings.TrimSpace(transaction.CorrespondingUser.FirstName + " " + transaction.CorrespondingUser.LastName)
+	}
+
+	// money leaves the owner on a debit and arrives on a credit
+	from, to := owner, counterparty
+	if transaction.Type == "CREDIT" {
+		from, to = counterparty, owner
+	}
+
+	title := "Transaction Receipt"
+	if transaction.Category != "" {
+		title = transaction.Category + " Receipt"
+	}
+
+	return ReceiptResponse{
+		ReceiptNumber: transaction.ID.String(),
+		Title:         title,
+		Date:          transaction.CreatedAt.Format("2006-01-02 15:04:05"),
+		Type:          transaction.Type,
+		Category:      transaction.Category,
+		Amount:        formatAmount(transaction.Amount),
+		Status:        transaction.Status,
+		From:          from,
+		To:            to,
+		Remarks:       transaction.Remarks,
+	}
+}
+
+func receiptText(receipt ReceiptResponse) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n", receipt.Title)
+	fmt.Fprintf(&text, "%s\n", strings.Repeat("=", len(receipt.Title)))
+	fmt.Fprintf(&text, "Receipt No. : %s\n", receipt.ReceiptNumber)
+	fmt.Fprintf(&text, "Date        : %s\n", receipt.Date)
+	fmt.Fprintf(&text, "Type        : %s\n", receipt.Type)
+	fmt.Fprintf(&text, "Category    : %s\n", receipt.Category)
+	fmt.Fprintf(&text, "Amount      : %s\n", receipt.Amount)
+	fmt.Fprintf(&text, "Status      : %s\n", receipt.Status)
+	fmt.Fprintf(&text, "From        : %s\n", receipt.From)
+	fmt.Fprintf(&text, "To          : %s\n", receipt.To)
+	fmt.Fprintf(&text, "Remarks     : %s\n", receipt.Remarks)
+	return text.String()
+}
+
+// formatAmount groups thousands, e.g. 1500000 becomes "1,500,000".
+func formatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + grouped.String()
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+)
+
+type (
+	RecipientPreviewQuery struct {
+		TargetUser string `query:"target_user" json:"target_user" validate:"required,max=255"`
+	}
+
+	RecipientPreviewResponse struct {
+		TargetUser string `json:"target_user"`
+		MaskedName string `json:"masked_name"`
+	}
+)
+
+// PreviewRecipient shows who a phone number or handle belongs to, with the
+// name masked, so the sender can check it before confirming a transfer.
+func PreviewRecipient(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	query := new(RecipientPreviewQuery)
+	if err := c.QueryParser(query); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Query",
+		})
+	}
+	if err := validation.Struct(query); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	recipient, err := services.LookUpRecipient(userUuid, query.TargetUser)
+	if err != nil {
+		return transactionErrorResponse(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": &RecipientPreviewResponse{
+			TargetUser: query.TargetUser,
+			MaskedName: services.MaskName(recipient.FirstName, recipient.LastName),
+		},
+	})
+}
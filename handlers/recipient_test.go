@@ -0,0 +1,63 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecipientLookupsShareOneThrottle(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("RECIPIENT_LOOKUP_MAX_PER_HOUR", "3")
+	sender, accessToken := createTestUser(t, "081200000001", "Alice")
+	fundTestUser(t, sender, 100000)
+	createTestUser(t, "081200000002", "Bob")
+
+	startAt := time.Now().Add(time.Hour).Format("2006-01-02 15:04:05")
+	lookups := []struct {
+		method string
+		path   string
+		body   map[string]interface{}
+	}{
+		{http.MethodGet, "/transfer/recipient?target_user=081299999999", nil},
+		{http.MethodPost, "/transfer", map[string]interface{}{"amount": 1000, "target_user": "081299999998"}},
+		{http.MethodPost, "/scheduled-transfers", map[string]interface{}{
+			"amount": 1000, "target_user": "081299999997", "frequency": "ONCE", "start_at": startAt,
+		}},
+	}
+	for _, lookup := range lookups {
+		response, _ := doRequest(t, app, lookup.method, lookup.path, accessToken, lookup.body, nil)
+		if response.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s %s returned %d, want %d", lookup.method, lookup.path, response.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	// a real recipient is hidden behind the same limit, before step-up
+	body := map[string]interface{}{"amount": 1000, "target_user": "081200000002"}
+	response, result := doRequest(t, app, http.MethodPost, "/transfer", accessToken, body, nil)
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("transfer returned %d %v, want %d", response.StatusCode, result, http.StatusTooManyRequests)
+	}
+	if response.Header.Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+}
+
+func TestPreviewRecipientMasksTheRecipient(t *testing.T) {
+	app := newTestApp(t)
+	_, accessToken := createTestUser(t, "081200000001", "Alice")
+	createTestUser(t, "081200000002", "Bob")
+
+	response, body := doRequest(t, app, http.MethodGet, "/transfer/recipient?target_user=081200000002", accessToken, nil, nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("preview returned %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	result, _ := body["result"].(map[string]interface{})
+	if result["masked_name"] != "B** D**" {
+		t.Errorf("masked name is %v, want %q", result["masked_name"], "B** D**")
+	}
+	if _, ok := result["handle"]; ok {
+		t.Error("preview exposes the handle")
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type (
+	RefundTransactionRequest struct {
+		Amount int64  `json:"amount" validate:"gte=0"`
+		Reason string `json:"reason" validate:"required,max=255"`
+	}
+
+	RefundTransactionResponse struct {
+		Refund   TransactionResponse `json:"refund"`
+		Original TransactionResponse `json:"original"`
+	}
+)
+
+// RefundTransaction refunds a payment or transfer on behalf of a customer.
+// Leaving out the amount refunds whatever has not been refunded yet.
+func RefundTransaction(c *fiber.Ctx) error {
+	transactionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Transaction ID",
+		})
+	}
+
+	json := new(RefundTransactionRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	refund, original, err := services.RefundTransaction(transactionUuid, json.Amount, json.Reason)
+	if err == services.ErrTransactionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Transaction not found",
+		})
+	}
+	if err == services.ErrTransactionNotRefundable || err == services.ErrRefundExceedsAmount {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return transactionErrorResponse(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": &RefundTransactionResponse{
+			Refund:   newTransactionResponse(refund),
+			Original: newTransactionResponse(original),
+		},
+	})
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type (
+	CreateScheduledTransferRequest struct {
+		TargetUser string `json:"target_user" validate:"required,max=255"`
+		Amount     int64  `json:"amount" validate:"required,gt=0"`
+		Remarks    string `json:"remarks" validate:"max=255"`
+		Frequency  string `json:"frequency" validate:"required,oneof=ONCE DAILY WEEKLY MONTHLY"`
+		StartAt    string `json:"start_at" validate:"required,datetime=2006-01-02 15:04:05"`
+		EndAt      string `json:"end_at" validate:"omitempty,datetime=2006-01-02 15:04:05"`
+		Pin        string `json:"pin" validate:"omitempty,pin"`
+	}
+
+	ScheduledTransferResponse struct {
+		ScheduledTransferID string `json:"scheduled_transfer_id"`
+		RecipientID         string `json:"recipient_id"`
+		RecipientName       string `json:"recipient_name"`
+		Amount              int64  `json:"amount"`
+		Remarks             string `json:"remarks"`
+		Frequency           string `json:"frequency"`
+		Status              string `json:"status"`
+		StartAt             string `json:"start_at"`
+		EndAt               string `json:"end_at"`
+		NextRunAt           string `json:"next_run_at"`
+		LastRunAt           string `json:"last_run_at"`
+		Attempts            int    `json:"attempts"`
+		LastError           string `json:"last_error"`
+		CreatedDate         string `json:"created_date"`
+	}
+)
+
+// CreateScheduledTransfer sets up a future-dated (frequency ONCE) or
+// recurring transfer. Like a transfer made now it needs the PIN when step-up
+// applies.
+func CreateScheduledTransfer(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	json := new(CreateScheduledTransferRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	recipient, err := services.LookUpRecipient(userUuid, json.TargetUser)
+	if err != nil {
+		return transactionErrorResponse(c, err)
+	}
+
+	scope := services.TransactionScope{Category: services.CategoryTransfer, Amount: json.Amount, RecipientID: &recipient.ID}
+	stepUp, ok, err := authorizeStepUp(c, userUuid, scope, json.Pin)
+	if !ok {
+		return err
+	}
+
+	request := services.NewScheduledTransferRequest{
+		RecipientID: recipient.ID,
+		Amount:      json.Amount,
+		Remarks:     json.Remarks,
+		Frequency:   json.Frequency,
+	}
+	request.StartAt, _ = time.ParseInLocation("2006-01-02 15:04:05", json.StartAt, time.Local)
+	if json.EndAt != "" {
+		endAt, _ := time.ParseInLocation("2006-01-02 15:04:05", json.EndAt, time.Local)
+		request.EndAt = &endAt
+	}
+
+	schedule, err := services.CreateScheduledTransfer(userUuid, request)
+	if err != nil {
+		stepUp.Release(c.UserContext())
+	}
+	if err == services.ErrScheduleStartInPast || err == services.ErrScheduleEndBeforeStart || err == services.ErrInvalidScheduleFrequency {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return transactionErrorResponse(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newScheduledTransferResponse(schedule),
+	})
+}
+
+func GetScheduledTransfers(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	schedules, err := services.GetScheduledTransfers(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := []ScheduledTransferResponse{}
+	for i := range schedules {
+		result = append(result, newScheduledTransferResponse(&schedules[i]))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
+
+func PauseScheduledTransfer(c *fiber.Ctx) error {
+	return changeScheduledTransfer(c, services.PauseScheduledTransfer)
+}
+
+func ResumeScheduledTransfer(c *fiber.Ctx) error {
+	return changeScheduledTransfer(c, services.ResumeScheduledTransfer)
+}
+
+func CancelScheduledTransfer(c *fiber.Ctx) error {
+	return changeScheduledTransfer(c, services.CancelScheduledTransfer)
+}
+
+func changeScheduledTransfer(c *fiber.Ctx, change func(uuid.UUID, uuid.UUID) (*services.ScheduledTransfer, error)) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	scheduleUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Scheduled Transfer ID",
+		})
+	}
+
+	schedule, err := change(userUuid, scheduleUuid)
+	if err == services.ErrScheduledTransferNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Scheduled transfer not found",
+		})
+	}
+	if err == services.ErrScheduleStatusConflict {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": newScheduledTransferResponse(schedule),
+	})
+}
+
+func newScheduledTransferResponse(schedule *services.ScheduledTransfer) ScheduledTransferResponse {
+	return ScheduledTransferResponse{
+		ScheduledTransferID: schedule.ID.String(),
+		RecipientID:         schedule.RecipientID.String(),
+		RecipientName:       strings.TrimSpace(schedule.Recipient.FirstName + " " + schedule.Recipient.LastName),
+		Amount:              schedule.Amount,
+		Remarks:             schedule.Remarks,
+		Frequency:           schedule.Frequency,
+		Status:              schedule.Status,
+		StartAt:             schedule.StartAt.Format("2006-01-02 15:04:05"),
+		EndAt:               formatOptionalTime(schedule.EndAt),
+		NextRunAt:           formatOptionalTime(schedule.NextRunAt),
+		LastRunAt:           formatOptionalTime(schedule.LastRunAt),
+		Attempts:            schedule.Attempts,
+		LastError:           schedule.LastError,
+		CreatedDate:         schedule.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func formatOptionalTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+type (
+	SessionResponse struct {
+		SessionID  string `json:"session_id"`
+		DeviceName string `json:"device_name"`
+		UserAgent  string `json:"user_agent"`
+		IPAddress  string `json:"ip_address"`
+		Current    bool   `json:"current"`
+		LastSeenAt string `json:"last_seen_at"`
+		CreatedAt  string `json:"created_at"`
+	}
+)
+
+func GetSessions(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	currentSessionUuid, err := extractSessionUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	sessions, err := services.GetActiveSessions(userUuid)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := []SessionResponse{}
+	for _, session := range sessions {
+		result = append(result, SessionResponse{
+			SessionID:  session.ID.String(),
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			Current:    session.ID == currentSessionUuid,
+			LastSeenAt: session.LastSeenAt.Format("2006-01-02 15:04:05"),
+			CreatedAt:  session.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
+
+func DeleteSession(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	sessionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Session ID",
+		})
+	}
+
+	err = services.RevokeSession(userUuid, sessionUuid)
+	if err == services.ErrSessionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Session not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+	})
+}
@@ -0,0 +1,83 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTransactionTokenIsBoundToTheTransaction(t *testing.T) {
+	app := newTestApp(t)
+	sender, accessToken := createTestUser(t, "081200000001", "Alice")
+	fundTestUser(t, sender, 100000)
+	createTestUser(t, "081200000002", "Bob")
+	createTestUser(t, "081200000003", "Carol")
+
+	transactionToken := verifyPin(t, app, accessToken, map[string]interface{}{
+		"pin": "123456", "category": "Transfer", "amount": 10000, "target_user": "081200000002",
+	})
+	headers := map[string]string{"X-Transaction-Token": transactionToken}
+
+	mismatches := []struct {
+		name string
+		path string
+		body map[string]interface{}
+	}{
+		{"larger amount", "/transfer", map[string]interface{}{"amount": 10001, "target_user": "081200000002"}},
+		{"other recipient", "/transfer", map[string]interface{}{"amount": 10000, "target_user": "081200000003"}},
+		{"payment", "/payment", map[string]interface{}{"amount": 2000000, "remarks": "x"}},
+	}
+	for _, mismatch := range mismatches {
+		response, body := doRequest(t, app, http.MethodPost, mismatch.path, accessToken, mismatch.body, headers)
+		if response.StatusCode != http.StatusForbidden || body["code"] != "INVALID_TRANSACTION_TOKEN" {
+			t.Errorf("%s returned %d %v, want %d INVALID_TRANSACTION_TOKEN", mismatch.name, response.StatusCode, body, http.StatusForbidden)
+		}
+	}
+
+	// a smaller amount is within what was confirmed
+	body := map[string]interface{}{"amount": 5000, "target_user": "081200000002"}
+	response, result := doRequest(t, app, http.MethodPost, "/transfer", accessToken, body, headers)
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("transfer returned %d %v, want %d", response.StatusCode, result, http.StatusAccepted)
+	}
+
+	response, _ = doRequest(t, app, http.MethodPost, "/transfer", accessToken, body, headers)
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("reusing the token returned %d, want %d", response.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestTransactionTokenSurvivesAFailedTransfer(t *testing.T) {
+	app := newTestApp(t)
+	sender, accessToken := createTestUser(t, "081200000001", "Alice")
+	createTestUser(t, "081200000002", "Bob")
+
+	transactionToken := verifyPin(t, app, accessToken, map[string]interface{}{
+		"pin": "123456", "category": "Transfer", "amount": 10000, "target_user": "081200000002",
+	})
+	headers := map[string]string{"X-Transaction-Token": transactionToken}
+	body := map[string]interface{}{"amount": 10000, "target_user": "081200000002"}
+
+	response, _ := doRequest(t, app, http.MethodPost, "/transfer", accessToken, body, headers)
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("transfer without balance returned %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+
+	fundTestUser(t, sender, 10000)
+	response, result := doRequest(t, app, http.MethodPost, "/transfer", accessToken, body, headers)
+	if response.StatusCode != http.StatusAccepted {
+		t.Errorf("retrying with the token returned %d %v, want %d", response.StatusCode, result, http.StatusAccepted)
+	}
+}
+
+func verifyPin(t *testing.T, app *fiber.App, accessToken string, body map[string]interface{}) string {
+	t.Helper()
+
+	response, result := doRequest(t, app, http.MethodPost, "/pin/verify", accessToken, body, nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("verifying the PIN returned %d %v", response.StatusCode, result)
+	}
+	token, _ := result["result"].(map[string]interface{})["transaction_token"].(string)
+	return token
+}
@@ -1,57 +1,126 @@
 package handlers
 
 import (
-	"database/sql"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
 )
 
 type (
 	CreateTopUpRequest struct {
-		Amount  int64  `json:"amount" validate:"required"`
-		Remarks string `json:"remarks"`
+		Amount  int64  `json:"amount" validate:"required,gt=0"`
+		Remarks string `json:"remarks" validate:"max=255"`
+		Channel string `json:"channel" validate:"omitempty,oneof=BANK_TRANSFER VIRTUAL_ACCOUNT CARD RETAIL"`
 	}
 
+	// BalanceAfter in the create responses is net of the fee
 	CreateTopUpResponse struct {
 		TopUpID       string `json:"top_up_id"`
 		AmountTopUp   int64  `json:"amount_top_up"`
+		Channel       string `json:"channel"`
+		Fee           int64  `json:"fee"`
 		BalanceBefore int64  `json:"balance_before"`
 		BalanceAfter  int64  `json:"balance_after"`
 		CreatedDate   string `json:"created_date"`
 	}
 
 	CreatePaymentRequest struct {
-		Amount  int64  `json:"amount" validate:"required"`
-		Remarks string `json:"remarks"`
+		Amount      int64  `json:"amount" validate:"required,gt=0"`
+		Remarks     string `json:"remarks" validate:"max=255"`
+		SubCategory string `json:"sub_category" validate:"max=50"`
+		Pin         string `json:"pin" validate:"omitempty,pin"`
 	}
 
 	CreatePaymentResponse struct {
 		PaymentID     string `json:"payment_id"`
 		Amount        int64  `json:"amount"`
+		Fee           int64  `json:"fee"`
 		Remarks       string `json:"remarks"`
+		SubCategory   string `json:"sub_category"`
 		BalanceBefore int64  `json:"balance_before"`
 		BalanceAfter  int64  `json:"balance_after"`
 		CreatedDate   string `json:"created_date"`
 	}
 
 	CreateTransferRequest struct {
-		Amount     int64  `json:"amount" validate:"required"`
-		TargetUser string `json:"target_user" validate:"required"`
-		Remarks    string `json:"remarks"`
+		Amount     int64  `json:"amount" validate:"required,gt=0"`
+		TargetUser string `json:"target_user" validate:"required,max=255"`
+		Remarks    string `json:"remarks" validate:"max=255"`
+		Pin        string `json:"pin" validate:"omitempty,pin"`
 	}
 
 	CreateTransferResponse struct {
 		TransferID    string `json:"transfer_id"`
 		Amount        int64  `json:"amount"`
+		Fee           int64  `json:"fee"`
 		Remarks       string `json:"remarks"`
+		Status        string `json:"status"`
 		BalanceBefore int64  `json:"balance_before"`
 		BalanceAfter  int64  `json:"balance_after"`
 		CreatedDate   string `json:"created_date"`
 	}
+
+	ListTransactionsQuery struct {
+		Type        string `query:"type" json:"type" validate:"omitempty,oneof=CREDIT DEBIT"`
+		Category    string `query:"category" json:"category" validate:"max=50"`
+		SubCategory string `query:"sub_category" json:"sub_category" validate:"max=50"`
+		Status      string `query:"status" json:"status" validate:"omitempty,oneof=PENDING SUCCESS FAILED REVERSED CANCELLED"`
+		From        string `query:"from" json:"from" validate:"omitempty,datetime=2006-01-02"`
+		To          string `query:"to" json:"to" validate:"omitempty,datetime=2006-01-02"`
+		MinAmount   *int64 `query:"min_amount" json:"min_amount" validate:"omitempty,gte=0"`
+		MaxAmount   *int64 `query:"max_amount" json:"max_amount" validate:"omitempty,gte=0"`
+		Limit       int    `query:"limit" json:"limit" validate:"omitempty,gt=0,max=100"`
+		Cursor      string `query:"cursor" json:"cursor"`
+	}
+
+	CounterpartyResponse struct {
+		UserID      string `json:"user_id"`
+		Name        string `json:"name"`
+		PhoneNumber string `json:"phone_number"`
+	}
+
+	TransactionResponse struct {
+		TransactionID         string                `json:"transaction_id"`
+		OriginalTransactionID string                `json:"original_transaction_id"`
+		Type                  string                `json:"type"`
+		Category              string                `json:"category"`
+		SubCategory           string                `json:"sub_category"`
+		Channel               string                `json:"channel"`
+		Amount                int64                 `json:"amount"`
+		Fee                   int64                 `json:"fee"`
+		RefundedAmount        int64                 `json:"refunded_amount"`
+		Remarks               string                `json:"remarks"`
+		Status                string                `json:"status"`
+		BalanceBefore         int64                 `json:"balance_before"`
+		BalanceAfter          int64                 `json:"balance_after"`
+		Counterparty          *CounterpartyResponse `json:"counterparty"`
+		CreatedDate           string                `json:"created_date"`
+	}
+
+	ListTransactionsResponse struct {
+		Transactions []TransactionResponse `json:"transactions"`
+		NextCursor   string                `json:"next_cursor"`
+	}
+
+	StatusHistoryResponse struct {
+		FromStatus  string `json:"from_status"`
+		ToStatus    string `json:"to_status"`
+		Reason      string `json:"reason"`
+		CreatedDate string `json:"created_date"`
+	}
+
+	TransactionDetailResponse struct {
+		TransactionResponse
+		StatusHistory []StatusHistoryResponse `json:"status_history"`
+	}
 )
 
 func CreateTopUp(c *fiber.Ctx) error {
@@ -63,23 +132,24 @@ func CreateTopUp(c *fiber.Ctx) error {
 	}
 
 	json := new(CreateTopUpRequest)
-	if err := c.BodyParser(json); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
-		})
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	if json.Channel == "" {
+		json.Channel = services.ChannelBankTransfer
 	}
 
 	newTransaction := services.NewTransactionRequest{
 		UserID:   userUuid,
 		Amount:   json.Amount,
 		Remarks:  json.Remarks,
-		Category: "TopUp",
+		Category: services.CategoryTopUp,
+		Channel:  json.Channel,
 	}
 	transaction, err := services.CreateCreditTransaction(userUuid, newTransaction)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return transactionErrorResponse(c, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(fiber.Map{
@@ -87,8 +157,10 @@ func CreateTopUp(c *fiber.Ctx) error {
 		"result": &CreateTopUpResponse{
 			TopUpID:       transaction.ID.String(),
 			AmountTopUp:   transaction.Amount,
+			Channel:       transaction.Channel,
+			Fee:           transaction.Fee,
 			BalanceBefore: transaction.BalanceBefore,
-			BalanceAfter:  transaction.BalanceAfter,
+			BalanceAfter:  transaction.BalanceAfter - transaction.Fee,
 			CreatedDate:   transaction.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
@@ -102,24 +174,29 @@ func CreatePayment(c *fiber.Ctx) error {
 		})
 	}
 
-	json := new(CreateTopUpRequest)
-	if err := c.BodyParser(json); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
-		})
+	json := new(CreatePaymentRequest)
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
+	}
+
+	scope := services.TransactionScope{Category: services.CategoryPayment, Amount: json.Amount}
+	stepUp, ok, err := authorizeStepUp(c, userUuid, scope, json.Pin)
+	if !ok {
+		return err
 	}
 
 	newTransaction := services.NewTransactionRequest{
-		UserID:   userUuid,
-		Amount:   json.Amount,
-		Remarks:  json.Remarks,
-		Category: "Payment",
+		UserID:      userUuid,
+		Amount:      json.Amount,
+		Remarks:     json.Remarks,
+		Category:    services.CategoryPayment,
+		SubCategory: json.SubCategory,
+		Channel:     services.ChannelApp,
 	}
 	transaction, err := services.CreateDebitTransaction(userUuid, newTransaction)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		stepUp.Release(c.UserContext())
+		return transactionErrorResponse(c, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(fiber.Map{
@@ -127,9 +204,11 @@ func CreatePayment(c *fiber.Ctx) error {
 		"result": &CreatePaymentResponse{
 			PaymentID:     transaction.ID.String(),
 			Amount:        transaction.Amount,
+			Fee:           transaction.Fee,
 			Remarks:       transaction.Remarks,
+			SubCategory:   transaction.SubCategory,
 			BalanceBefore: transaction.BalanceBefore,
-			BalanceAfter:  transaction.BalanceAfter,
+			BalanceAfter:  transaction.BalanceAfter - transaction.Fee,
 			CreatedDate:   transaction.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
@@ -144,64 +223,341 @@ func CreateTransfer(c *fiber.Ctx) error {
 	}
 
 	json := new(CreateTransferRequest)
-	if err := c.BodyParser(json); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid JSON",
-		})
+	if ok, err := parseAndValidate(c, json); !ok {
+		return err
 	}
 
-	targetUserUuid, err := uuid.Parse(json.TargetUser)
+	recipient, err := services.LookUpRecipient(userUuid, json.TargetUser)
 	if err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid Target User UUID",
-		})
+		return transactionErrorResponse(c, err)
 	}
 
-	newTransactions := []services.NewTransactionRequest{
-		{
-			UserID:   userUuid,
-			Amount:   json.Amount,
-			Remarks:  json.Remarks,
-			Category: "Transfer",
-			Type: sql.NullString{
-				String: "DEBIT",
-				Valid:  true,
-			},
-			CorrespondingUserID: targetUserUuid,
-		},
-		{
-			UserID:   targetUserUuid,
-			Amount:   json.Amount,
-			Remarks:  json.Remarks,
-			Category: "Transfer",
-			Type: sql.NullString{
-				String: "CREDIT",
-				Valid:  true,
-			},
-			CorrespondingUserID: userUuid,
-		},
+	scope := services.TransactionScope{Category: services.CategoryTransfer, Amount: json.Amount, RecipientID: &recipient.ID}
+	stepUp, ok, err := authorizeStepUp(c, userUuid, scope, json.Pin)
+	if !ok {
+		return err
 	}
-	transaction, err := services.CreateTransferTransaction(userUuid, newTransactions)
+
+	newTransaction := services.NewTransactionRequest{
+		UserID:              userUuid,
+		Amount:              json.Amount,
+		Remarks:             json.Remarks,
+		Category:            services.CategoryTransfer,
+		Channel:             services.ChannelApp,
+		CorrespondingUserID: recipient.ID,
+	}
+	transaction, err := services.SubmitTransfer(c.UserContext(), userUuid, newTransaction)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		stepUp.Release(c.UserContext())
+		return transactionErrorResponse(c, err)
 	}
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
 		"status": "SUCCESS",
 		"result": &CreateTransferResponse{
 			TransferID:    transaction.ID.String(),
 			Amount:        transaction.Amount,
+			Fee:           transaction.Fee,
 			Remarks:       transaction.Remarks,
+			Status:        transaction.Status,
 			BalanceBefore: transaction.BalanceBefore,
-			BalanceAfter:  transaction.BalanceAfter,
+			BalanceAfter:  transaction.BalanceAfter - transaction.Fee,
 			CreatedDate:   transaction.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
 }
 
+func ListTransactions(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	query := new(ListTransactionsQuery)
+	if err := c.QueryParser(query); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Query",
+		})
+	}
+	if err := validation.Struct(query); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	filter := services.TransactionFilter{
+		Type:        query.Type,
+		Category:    query.Category,
+		SubCategory: query.SubCategory,
+		Status:      query.Status,
+		MinAmount:   query.MinAmount,
+		MaxAmount:   query.MaxAmount,
+	}
+	if query.From != "" {
+		from, _ := time.ParseInLocation("2006-01-02", query.From, time.Local)
+		filter.From = &from
+	}
+	if query.To != "" {
+		// the end date is inclusive
+		to, _ := time.ParseInLocation("2006-01-02", query.To, time.Local)
+		to = to.AddDate(0, 0, 1)
+		filter.To = &to
+	}
+
+	page, err := services.ListTransactions(userUuid, filter, query.Cursor, query.Limit)
+	if err == services.ErrInvalidCursor {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Cursor",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := ListTransactionsResponse{
+		Transactions: []TransactionResponse{},
+		NextCursor:   page.NextCursor,
+	}
+	for _, transaction := range page.Transactions {
+		result.Transactions = append(result.Transactions, newTransactionResponse(&transaction))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
+
+func GetTransaction(c *fiber.Ctx) error {
+	userUuid, err := extractUserUuidFromContext(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	transactionUuid, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Transaction ID",
+		})
+	}
+
+	transaction, history, err := services.GetUserTransaction(userUuid, transactionUuid)
+	if err == services.ErrTransactionNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": "Transaction not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	result := TransactionDetailResponse{
+		TransactionResponse: newTransactionResponse(transaction),
+		StatusHistory:       []StatusHistoryResponse{},
+	}
+	for _, entry := range history {
+		result.StatusHistory = append(result.StatusHistory, StatusHistoryResponse{
+			FromStatus:  entry.FromStatus,
+			ToStatus:    entry.ToStatus,
+			Reason:      entry.Reason,
+			CreatedDate: entry.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status": "SUCCESS",
+		"result": result,
+	})
+}
+
+func newTransactionResponse(transaction *services.Transaction) TransactionResponse {
+	response := TransactionResponse{
+		TransactionID:  transaction.ID.String(),
+		Type:           transaction.Type,
+		Category:       transaction.Category,
+		SubCategory:    transaction.SubCategory,
+		Channel:        transaction.Channel,
+		Amount:         transaction.Amount,
+		Fee:            transaction.Fee,
+		RefundedAmount: transaction.RefundedAmount,
+		Remarks:        transaction.Remarks,
+		Status:         transaction.Status,
+		BalanceBefore:  transaction.BalanceBefore,
+		BalanceAfter:   transaction.BalanceAfter,
+		CreatedDate:    transaction.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+
+	if transaction.OriginalTransactionID != nil {
+		response.OriginalTransactionID = transaction.OriginalTransactionID.String()
+	}
+
+	if transaction.CorrespondingUserID != nil {
+		response.Counterparty = &CounterpartyResponse{
+			UserID:      transaction.CorrespondingUserID.String(),
+			Name:        strings.TrimSpace(transaction.CorrespondingUser.FirstName + " " + transaction.CorrespondingUser.LastName),
+			PhoneNumber: maskPhoneNumber(transaction.CorrespondingUser.PhoneNumber),
+		}
+	}
+
+	return response
+}
+
+// maskPhoneNumber keeps only the first four and last three digits.
+func maskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 7 {
+		return strings.Repeat("*", len(phoneNumber))
+	}
+	return phoneNumber[:4] + strings.Repeat("*", len(phoneNumber)-7) + phoneNumber[len(phoneNumber)-3:]
+}
+
+// transactionErrorResponse maps business rule errors of the transaction
+// services to client errors.
+func transactionErrorResponse(c *fiber.Ctx, err error) error {
+	if validation.FieldErrors(err) != nil {
+		return validationErrorResponse(c, err)
+	}
+	if exceeded, ok := err.(*services.TransactionLimitExceededError); ok {
+		response := fiber.Map{
+			"code":    "LIMIT_EXCEEDED",
+			"message": err.Error(),
+			"period":  exceeded.Period,
+			"limit":   exceeded.Limit,
+		}
+		if exceeded.Period != services.LimitPeriodTransaction {
+			response["remaining"] = exceeded.Remaining
+		}
+		return c.Status(http.StatusBadRequest).JSON(response)
+	}
+	if throttled, ok := err.(*services.RecipientLookupThrottledError); ok {
+		retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+			"message":     "Recipients were looked up too often, please wait before trying again",
+			"retry_after": retryAfter,
+		})
+	}
+
+	switch err {
+	case services.ErrInsufficientBalance, services.ErrInvalidCategory, services.ErrInvalidSubCategory, services.ErrSelfTransfer,
+		services.ErrFeeExceedsAmount:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	case services.ErrKycBalanceCapExceeded, services.ErrKycMonthlyInflowExceeded:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"code":    "KYC_CAP_EXCEEDED",
+			"message": err.Error(),
+		})
+	case services.ErrRecipientNotFound:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	case services.ErrAccountFrozen, services.ErrAccountSuspended, services.ErrAccountClosed:
+		return accountStatusErrorResponse(c, err)
+	}
+
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"message": err.Error(),
+	})
+}
+
+func accountStatusErrorResponse(c *fiber.Ctx, err error) error {
+	code := map[error]string{
+		services.ErrAccountFrozen:    "ACCOUNT_FROZEN",
+		services.ErrAccountSuspended: "ACCOUNT_SUSPENDED",
+		services.ErrAccountClosed:    "ACCOUNT_CLOSED",
+	}[err]
+
+	return c.Status(http.StatusForbidden).JSON(fiber.Map{
+		"code":    code,
+		"message": err.Error(),
+	})
+}
+
+// authorizeStepUp enforces the PIN re-confirmation rule for money leaving the
+// wallet. It accepts either the PIN in the request body or a transaction
+// token from POST /pin/verify in the X-Transaction-Token header, which must
+// have been issued for this kind of transaction. A spent token is returned
+// so the caller can release it if the transaction fails. When it returns
+// false the response has already been written.
+func authorizeStepUp(c *fiber.Ctx, userUuid uuid.UUID, scope services.TransactionScope, pin string) (*services.TransactionTokenClaim, bool, error) {
+	required, err := services.StepUpRequired(userUuid, scope.Amount, scope.RecipientID)
+	if err != nil {
+		return nil, false, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+	if !required {
+		return nil, true, nil
+	}
+
+	if transactionToken := c.Get("X-Transaction-Token"); transactionToken != "" {
+		sessionUuid, err := extractSessionUuidFromContext(c)
+		if err != nil {
+			return nil, false, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Invalid UUID",
+			})
+		}
+
+		claim, err := services.ConsumeTransactionToken(c.UserContext(), transactionToken, userUuid, sessionUuid, scope)
+		if err == services.ErrTransactionTokenInvalid {
+			return nil, false, c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"code":    "INVALID_TRANSACTION_TOKEN",
+				"message": "Transaction token is invalid, was issued for another transaction or has already been used",
+			})
+		}
+		if err != nil {
+			return nil, false, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return claim, true, nil
+	}
+
+	if pin == "" {
+		return nil, false, c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"code":    "STEP_UP_REQUIRED",
+			"message": "PIN confirmation is required for this transaction",
+		})
+	}
+
+	user, err := services.GetUserByID(userUuid)
+	if err != nil {
+		return nil, false, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	err = services.VerifyPin(user, pin, c.IP())
+	if blocked, ok := err.(*services.LoginBlockedError); ok {
+		return nil, false, loginBlockedResponse(c, blocked)
+	}
+	if err == services.ErrPinMismatch {
+		return nil, false, c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "PIN doesn't match",
+		})
+	}
+	if err != nil {
+		return nil, false, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return nil, true, nil
+}
+
 func extractUserUuidFromContext(c *fiber.Ctx) (uuid.UUID, error) {
 	stringUuid := c.Locals("userInfo").(jwt.MapClaims)["user_id"].(string)
 	return uuid.Parse(stringUuid)
 }
+
+func extractSessionUuidFromContext(c *fiber.Ctx) (uuid.UUID, error) {
+	stringUuid, _ := c.Locals("userInfo").(jwt.MapClaims)["sid"].(string)
+	return uuid.Parse(stringUuid)
+}
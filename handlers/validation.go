@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+)
+
+// parseAndValidate parses the JSON body into out and evaluates its validate
+// tags. When it returns false the response has already been written.
+func parseAndValidate(c *fiber.Ctx, out interface{}) (bool, error) {
+	if err := c.BodyParser(out); err != nil {
+		return false, c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid JSON",
+		})
+	}
+
+	if err := validation.Struct(out); err != nil {
+		return false, validationErrorResponse(c, err)
+	}
+
+	return true, nil
+}
+
+func validationErrorResponse(c *fiber.Ctx, err error) error {
+	return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+		"message": "Validation failed",
+		"errors":  validation.FieldErrors(err),
+	})
+}
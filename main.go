@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/router"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
 )
 
 func getenv(key, fallback string) string {
@@ -25,10 +27,41 @@ func main() {
 	app := fiber.New()
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, Idempotency-Key, X-Transaction-Token",
 	}))
 
 	database.ConnectDB()
+	if err := services.OpenWalletAccounts(); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.LoadSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.LoadFeeRules(); err != nil {
+		log.Fatal(err)
+	}
+	if adminPhoneNumber := os.Getenv("ADMIN_PHONE_NUMBER"); adminPhoneNumber != "" {
+		if err := services.EnsureAdmin(adminPhoneNumber); err != nil {
+			log.Fatal(err)
+		}
+	}
+	database.ConnectRedis()
+	if database.Redis != nil {
+		services.TokenRevocations = services.NewRedisTokenRevocationStore(database.Redis)
+		services.TransferJobs = services.NewRedisTransferQueue(database.Redis)
+	}
+	if outbox := os.Getenv("SMS_OUTBOX_FILE"); outbox != "" {
+		services.SMS = services.NewFileSMSSender(outbox)
+	}
+	if dir := os.Getenv("KYC_STORAGE_DIR"); dir != "" {
+		services.Documents = services.NewLocalDocumentStorage(dir)
+	}
+
+	go services.RunTransferWorker(context.Background())
+	go services.RunTransferScheduler(context.Background())
+	if err := services.RequeuePendingTransfers(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 
 	router.Initalize(app)
 	log.Fatal(app.Listen(":" + getenv("PORT", "3000")))
@@ -1,13 +1,12 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
 )
 
 func Auth(c *fiber.Ctx) error {
@@ -32,28 +31,67 @@ func Auth(c *fiber.Ctx) error {
 	}
 
 	accessToken := authHeaderParts[1]
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method invalid")
-		}
+	claims, err := services.ParseAccessToken(accessToken)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
 
-		jwtSecretKey := os.Getenv("JWT_SECRET_KEY")
-		return []byte(jwtSecretKey), nil
-	})
+	userId, _ := claims["user_id"].(string)
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
 
+	revoked, err := services.TokenRevocations.IsRevoked(c.UserContext(), jti, userId, issuedAt.Time)
 	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+	if revoked {
 		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Unauthenticated",
 		})
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
+	sessionId, _ := claims["sid"].(string)
+	sessionUuid, err := uuid.Parse(sessionId)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
+
+	userUuid, err := uuid.Parse(userId)
+	if err != nil {
 		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Unauthenticated",
 		})
 	}
 
+	err = services.ValidateSession(sessionUuid, userUuid, c.IP())
+	if err == services.ErrSessionNotFound || err == services.ErrSessionRevoked {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthenticated",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
 	c.Locals("userInfo", claims)
 	return c.Next()
 }
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key header and body. Requests without the header pass
+// through untouched. It must run after Auth since keys are scoped per user.
+func Idempotency(c *fiber.Ctx) error {
+	key := c.Get("Idempotency-Key")
+	if key == "" {
+		return c.Next()
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid Idempotency-Key",
+		})
+	}
+
+	userId, _ := c.Locals("userInfo").(jwt.MapClaims)["user_id"].(string)
+	userUuid, err := uuid.Parse(userId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Invalid UUID",
+		})
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	hash.Write(c.Body())
+	requestHash := hex.EncodeToString(hash.Sum(nil))
+
+	record, replay, err := services.BeginIdempotentRequest(userUuid, key, requestHash)
+	if err == services.ErrIdempotencyKeyMismatch || err == services.ErrIdempotencyKeyInProgress {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if replay {
+		c.Set("Idempotent-Replayed", "true")
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(record.StatusCode).Send(record.ResponseBody)
+	}
+
+	if err := c.Next(); err != nil {
+		services.ReleaseIdempotentRequest(record.ID)
+		return err
+	}
+
+	statusCode := c.Response().StatusCode()
+	if !isReplayableStatus(statusCode) {
+		if err := services.ReleaseIdempotentRequest(record.ID); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return nil
+	}
+
+	return services.CompleteIdempotentRequest(record.ID, statusCode, c.Response().Body())
+}
+
+// isReplayableStatus leaves out server errors and answers that ask the client
+// to do something before retrying (authenticate, confirm the PIN, wait).
+func isReplayableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusLocked, http.StatusTooManyRequests:
+		return false
+	}
+	return statusCode < http.StatusInternalServerError
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// RequireRoles only lets requests through when the role claim of the access
+// token is one of the given roles. It must run after Auth.
+func RequireRoles(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("userInfo").(jwt.MapClaims)
+		if !ok {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Unauthenticated",
+			})
+		}
+
+		role, _ := claims["role"].(string)
+		for _, allowed := range roles {
+			if role == allowed {
+				return c.Next()
+			}
+		}
+
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+}
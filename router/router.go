@@ -4,6 +4,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/handlers"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/middleware"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/services"
 )
 
 func Initalize(router *fiber.App) {
@@ -13,14 +14,56 @@ func Initalize(router *fiber.App) {
 		return c.Status(200).SendString("Hello, World!")
 	})
 
+	router.Get("/.well-known/jwks.json", handlers.JWKS)
+
 	router.Use(middleware.Json)
 
+	router.Post("/register/otp", handlers.RequestRegisterOtp)
 	router.Post("/register", handlers.Register)
 	router.Post("/login", handlers.Login)
+	router.Post("/refresh", handlers.Refresh)
+	router.Post("/pin/forgot", handlers.ForgotPin)
+	router.Post("/pin/reset", handlers.ResetPin)
 
 	router.Use(middleware.Auth)
+	router.Post("/logout", handlers.Logout)
 	router.Put("/profile", handlers.UpdateProfile)
-	router.Post("/topup", handlers.CreateTopUp)
-	router.Post("/payment", handlers.CreatePayment)
-	router.Post("/transfer", handlers.CreateTransfer)
+	router.Put("/pin", handlers.ChangePin)
+	router.Post("/pin/verify", handlers.VerifyPin)
+	router.Get("/sessions", handlers.GetSessions)
+	router.Delete("/sessions/:id", handlers.DeleteSession)
+	router.Post("/topup", middleware.Idempotency, handlers.CreateTopUp)
+	router.Post("/payment", middleware.Idempotency, handlers.CreatePayment)
+	router.Post("/transfer", middleware.Idempotency, handlers.CreateTransfer)
+	router.Get("/transfer/recipient", handlers.PreviewRecipient)
+	router.Get("/scheduled-transfers", handlers.GetScheduledTransfers)
+	router.Post("/scheduled-transfers", handlers.CreateScheduledTransfer)
+	router.Post("/scheduled-transfers/:id/pause", handlers.PauseScheduledTransfer)
+	router.Post("/scheduled-transfers/:id/resume", handlers.ResumeScheduledTransfer)
+	router.Post("/scheduled-transfers/:id/cancel", handlers.CancelScheduledTransfer)
+	router.Get("/fees", handlers.GetFeeRules)
+	router.Get("/fees/quote", handlers.QuoteFee)
+	router.Get("/limits", handlers.GetLimits)
+	router.Get("/kyc", handlers.GetKycStatus)
+	router.Post("/kyc/documents", handlers.SubmitKycDocument)
+	router.Get("/categories", handlers.GetCategories)
+	router.Get("/transactions", handlers.ListTransactions)
+	router.Get("/transactions/summary", handlers.GetSpendingSummary)
+	router.Get("/transactions/:id", handlers.GetTransaction)
+	router.Put("/transactions/:id/category", handlers.UpdateTransactionCategory)
+	router.Get("/transactions/:id/receipt", handlers.GetTransactionReceipt)
+
+	admin := router.Group("/admin", middleware.RequireRoles(services.RoleAdmin, services.RoleSupport))
+	admin.Post("/users/:id/unlock", handlers.UnlockUserLogin)
+	admin.Post("/ips/unlock", handlers.UnlockIPLogin)
+	admin.Put("/users/:id/role", middleware.RequireRoles(services.RoleAdmin), handlers.UpdateUserRole)
+	admin.Put("/users/:id/status", middleware.RequireRoles(services.RoleAdmin), handlers.UpdateUserStatus)
+	admin.Get("/users/:id/status-history", middleware.RequireRoles(services.RoleAdmin), handlers.GetUserStatusHistory)
+	admin.Post("/transactions/:id/refund", handlers.RefundTransaction)
+	admin.Get("/ledger/accounts", handlers.GetLedgerAccounts)
+	admin.Get("/ledger/verify", handlers.VerifyLedger)
+	admin.Get("/kyc/submissions", handlers.GetKycSubmissionsForReview)
+	admin.Get("/kyc/submissions/:id/document", handlers.GetKycDocument)
+	admin.Post("/kyc/submissions/:id/approve", handlers.ApproveKycSubmission)
+	admin.Post("/kyc/submissions/:id/reject", handlers.RejectKycSubmission)
 }
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"gorm.io/gorm"
+)
+
+const (
+	CategoryTopUp    = "TopUp"
+	CategoryPayment  = "Payment"
+	CategoryTransfer = "Transfer"
+	CategoryRefund   = "Refund"
+	CategoryFee      = "Fee"
+)
+
+var (
+	ErrInvalidCategory       = errors.New("category is invalid")
+	ErrInvalidSubCategory    = errors.New("sub-category is invalid")
+	ErrSubCategoryNotAllowed = errors.New("sub-categories can only be assigned to payments")
+)
+
+type TransactionCategory struct {
+	Name          string   `json:"name"`
+	SubCategories []string `json:"sub_categories"`
+}
+
+// TransactionCategories is the controlled vocabulary of categories, with the
+// sub-categories users may assign within each of them.
+var TransactionCategories = []TransactionCategory{
+	{Name: CategoryTopUp, SubCategories: []string{}},
+	{
+		Name: CategoryPayment,
+		SubCategories: []string{
+			"Food", "Groceries", "Transport", "Bills", "Shopping",
+			"Entertainment", "Health", "Education", "Other",
+		},
+	},
+	{Name: CategoryTransfer, SubCategories: []string{}},
+	{Name: CategoryRefund, SubCategories: []string{}},
+	{Name: CategoryFee, SubCategories: []string{}},
+}
+
+type CategorySpending struct {
+	Category         string
+	SubCategory      string
+	TotalAmount      int64
+	TransactionCount int64
+}
+
+// SetTransactionSubCategory assigns a sub-category to one of the user's
+// payments. An empty sub-category clears it.
+func SetTransactionSubCategory(userId uuid.UUID, transactionId uuid.UUID, subCategory string) (*Transaction, error) {
+	db := database.DB
+	var transaction Transaction
+	err := db.Preload("CorrespondingUser").
+		First(&transaction, &Transaction{ID: transactionId, UserID: userId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction.Category != CategoryPayment {
+		return nil, ErrSubCategoryNotAllowed
+	}
+	if err := checkCategory(transaction.Category, subCategory); err != nil {
+		return nil, err
+	}
+
+	transaction.SubCategory = subCategory
+	err = db.Model(&Transaction{}).
+		Where("id = ?", transaction.ID).
+		Update("sub_category", subCategory).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// GetSpendingSummary totals the user's successful debits in the month that
+// starts at monthStart, grouped by category and sub-category, biggest first.
+func GetSpendingSummary(userId uuid.UUID, monthStart time.Time) ([]CategorySpending, error) {
+	db := database.DB
+	summary := []CategorySpending{}
+	err := db.Model(&Transaction{}).
+		Select("category, sub_category, SUM(amount) AS total_amount, COUNT(*) AS transaction_count").
+		Where("user_id = ? AND type = ? AND status = ?", userId, "DEBIT", TransactionStatusSuccess).
+		Where("created_at >= ? AND created_at < ?", monthStart, monthStart.AddDate(0, 1, 0)).
+		Group("category, sub_category").
+		Order("total_amount desc").
+		Scan(&summary).Error
+	return summary, err
+}
+
+// checkCategory rejects categories outside the vocabulary and sub-categories
+// that do not belong to the category. The sub-category is optional.
+func checkCategory(category string, subCategory string) error {
+	for _, known := range TransactionCategories {
+		if known.Name != category {
+			continue
+		}
+
+		if subCategory == "" {
+			return nil
+		}
+		for _, knownSubCategory := range known.SubCategories {
+			if knownSubCategory == subCategory {
+				return nil
+			}
+		}
+		return ErrInvalidSubCategory
+	}
+
+	return ErrInvalidCategory
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidDocumentKey = errors.New("document key is invalid")
+
+// DocumentStorage keeps uploaded documents such as KYC photos. Keys are
+// slash-separated paths chosen by the caller.
+type DocumentStorage interface {
+	Save(ctx context.Context, key string, content io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Documents is the storage used for KYC documents. main points it at
+// KYC_STORAGE_DIR when that is set.
+var Documents DocumentStorage = NewLocalDocumentStorage("storage/kyc")
+
+// LocalDocumentStorage stores documents as files below a directory on the
+// local disk.
+type LocalDocumentStorage struct {
+	dir string
+}
+
+func NewLocalDocumentStorage(dir string) *LocalDocumentStorage {
+	return &LocalDocumentStorage{dir: dir}
+}
+
+func (s *LocalDocumentStorage) Save(ctx context.Context, key string, content io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a failed upload leaves nothing behind
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (s *LocalDocumentStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalDocumentStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path maps a key to a file and refuses keys that would leave the storage
+// directory.
+func (s *LocalDocumentStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", ErrInvalidDocumentKey
+	}
+	return filepath.Join(s.dir, cleaned), nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalDocumentStoragePath(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewLocalDocumentStorage(dir)
+
+	tests := []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{key: "user/document.jpg", want: filepath.Join(dir, "user", "document.jpg")},
+		{key: "/user/document.jpg", want: filepath.Join(dir, "user", "document.jpg")},
+		{key: "user//document.jpg", want: filepath.Join(dir, "user", "document.jpg")},
+		{key: "", err: ErrInvalidDocumentKey},
+		{key: "/", err: ErrInvalidDocumentKey},
+		{key: "..", err: ErrInvalidDocumentKey},
+		{key: "../outside.jpg", err: ErrInvalidDocumentKey},
+		{key: "user/../../outside.jpg", err: ErrInvalidDocumentKey},
+		{key: "user/../other/document.jpg", err: ErrInvalidDocumentKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			path, err := storage.path(test.key)
+			if err != test.err {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if path != test.want {
+				t.Errorf("got %q, want %q", path, test.want)
+			}
+			if err == nil && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				t.Errorf("%q is outside %q", path, dir)
+			}
+		})
+	}
+}
+
+func TestLocalDocumentStorageStaysInsideDir(t *testing.T) {
+	parent := t.TempDir()
+	storage := NewLocalDocumentStorage(filepath.Join(parent, "documents"))
+	ctx := context.Background()
+
+	if err := storage.Save(ctx, "../outside.jpg", strings.NewReader("document")); err != ErrInvalidDocumentKey {
+		t.Errorf("save got %v, want %v", err, ErrInvalidDocumentKey)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "outside.jpg")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the storage directory")
+	}
+
+	outside := filepath.Join(parent, "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Open(ctx, "../secret.txt"); err != ErrInvalidDocumentKey {
+		t.Errorf("open got %v, want %v", err, ErrInvalidDocumentKey)
+	}
+	if err := storage.Delete(ctx, "../secret.txt"); err != ErrInvalidDocumentKey {
+		t.Errorf("delete got %v, want %v", err, ErrInvalidDocumentKey)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("a file outside the storage directory was touched: %v", err)
+	}
+}
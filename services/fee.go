@@ -0,0 +1,283 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	FeeTypeFlat       = "FLAT"
+	FeeTypePercentage = "PERCENTAGE"
+	FeeTypeTiered     = "TIERED"
+)
+
+const (
+	ChannelApp            = "APP"
+	ChannelBankTransfer   = "BANK_TRANSFER"
+	ChannelVirtualAccount = "VIRTUAL_ACCOUNT"
+	ChannelCard           = "CARD"
+	ChannelRetail         = "RETAIL"
+)
+
+var ErrFeeExceedsAmount = errors.New("fee is not smaller than the amount")
+
+// FeeTier is one band of a tiered fee. It covers amounts up to UpTo; 0 means
+// no upper bound. The fee is Flat plus BasisPoints hundredths of a percent
+// of the amount.
+type FeeTier struct {
+	UpTo        int64 `json:"up_to"`
+	Flat        int64 `json:"flat"`
+	BasisPoints int64 `json:"basis_points"`
+}
+
+// FeeRule prices one category, optionally for one channel only. Min and Max
+// clamp the fee whatever its type; a Max of 0 means no maximum.
+type FeeRule struct {
+	Category    string    `json:"category"`
+	Channel     string    `json:"channel"`
+	Type        string    `json:"type"`
+	Flat        int64     `json:"flat"`
+	BasisPoints int64     `json:"basis_points"`
+	Tiers       []FeeTier `json:"tiers"`
+	Min         int64     `json:"min"`
+	Max         int64     `json:"max"`
+}
+
+type FeeQuote struct {
+	Category string `json:"category"`
+	Channel  string `json:"channel"`
+	Amount   int64  `json:"amount"`
+	Fee      int64  `json:"fee"`
+}
+
+// feeRules is replaced by LoadFeeRules when FEE_RULES_FILE is set.
+var feeRules = []FeeRule{
+	{Category: CategoryTopUp, Channel: ChannelCard, Type: FeeTypePercentage, BasisPoints: 150, Min: 1000},
+	{Category: CategoryTopUp, Channel: ChannelRetail, Type: FeeTypeFlat, Flat: 2500},
+	{
+		Category: CategoryTransfer,
+		Type:     FeeTypeTiered,
+		Tiers: []FeeTier{
+			{UpTo: 1000000},
+			{Flat: 2500},
+		},
+	},
+}
+
+// LoadFeeRules replaces the built-in fee rules with the JSON array in
+// FEE_RULES_FILE, if set. See fee_rules.example.json for the format.
+func LoadFeeRules() error {
+	path := os.Getenv("FEE_RULES_FILE")
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	rules := []FeeRule{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return fmt.Errorf("fee rules: %w", err)
+	}
+	for i, rule := range rules {
+		if err := checkFeeRule(rule); err != nil {
+			return fmt.Errorf("fee rule %d: %w", i, err)
+		}
+	}
+
+	feeRules = rules
+	return nil
+}
+
+func FeeRules() []FeeRule {
+	return feeRules
+}
+
+// QuoteFee works out the fee for an amount without charging it.
+func QuoteFee(category string, channel string, amount int64) (*FeeQuote, error) {
+	if err := checkCategory(category, ""); err != nil {
+		return nil, err
+	}
+
+	return &FeeQuote{
+		Category: category,
+		Channel:  channel,
+		Amount:   amount,
+		Fee:      calculateFee(category, channel, amount),
+	}, nil
+}
+
+// calculateFee prices an amount with the rule for its category and channel.
+// A rule for the exact channel wins over one for any channel; without a rule
+// there is no fee.
+func calculateFee(category string, channel string, amount int64) int64 {
+	var matched *FeeRule
+	for i, rule := range feeRules {
+		if rule.Category != category {
+			continue
+		}
+		if rule.Channel == channel {
+			matched = &feeRules[i]
+			break
+		}
+		if rule.Channel == "" && matched == nil {
+			matched = &feeRules[i]
+		}
+	}
+	if matched == nil {
+		return 0
+	}
+
+	var fee int64
+	switch matched.Type {
+	case FeeTypeFlat:
+		fee = matched.Flat
+	case FeeTypePercentage:
+		fee = basisPointsOf(amount, matched.BasisPoints)
+	case FeeTypeTiered:
+		for _, tier := range matched.Tiers {
+			if tier.UpTo == 0 || amount <= tier.UpTo {
+				fee = tier.Flat + basisPointsOf(amount, tier.BasisPoints)
+				break
+			}
+		}
+	}
+
+	if fee < matched.Min {
+		fee = matched.Min
+	}
+	if matched.Max > 0 && fee > matched.Max {
+		fee = matched.Max
+	}
+	return fee
+}
+
+// basisPointsOf rounds up so fractions of the smallest unit are charged.
+func basisPointsOf(amount int64, basisPoints int64) int64 {
+	return (amount*basisPoints + 9999) / 10000
+}
+
+func checkFeeRule(rule FeeRule) error {
+	if err := checkCategory(rule.Category, ""); err != nil {
+		return err
+	}
+	if rule.Category == CategoryRefund || rule.Category == CategoryFee {
+		return ErrInvalidCategory
+	}
+	if rule.Flat < 0 || rule.BasisPoints < 0 || rule.Min < 0 || rule.Max < 0 {
+		return errors.New("fees cannot be negative")
+	}
+	if rule.Max > 0 && rule.Min > rule.Max {
+		return errors.New("min is above max")
+	}
+
+	switch rule.Type {
+	case FeeTypeFlat, FeeTypePercentage:
+		return nil
+	case FeeTypeTiered:
+		if len(rule.Tiers) == 0 {
+			return errors.New("tiered rule has no tiers")
+		}
+		for i, tier := range rule.Tiers {
+			if tier.Flat < 0 || tier.BasisPoints < 0 {
+				return errors.New("fees cannot be negative")
+			}
+			if i > 0 && (rule.Tiers[i-1].UpTo == 0 || tier.UpTo != 0 && tier.UpTo <= rule.Tiers[i-1].UpTo) {
+				return errors.New("tiers must be in ascending order with the open-ended tier last")
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown fee type %q", rule.Type)
+}
+
+// chargeFee takes the fee for a transaction from the user's wallet as a
+// separate DEBIT that points at the transaction and is posted to the house
+// fees account. It fails with ErrInsufficientBalance when the wallet can't
+// cover the fee on top of the transaction.
+func chargeFee(tx *gorm.DB, transaction *Transaction, channel string) error {
+	fee := calculateFee(transaction.Category, channel, transaction.Amount)
+	if fee == 0 {
+		return nil
+	}
+	if transaction.Type == "CREDIT" && fee >= transaction.Amount {
+		return ErrFeeExceedsAmount
+	}
+
+	transaction.Fee = fee
+	if err := tx.Model(&Transaction{}).Where("id = ?", transaction.ID).Update("fee", fee).Error; err != nil {
+		return err
+	}
+
+	return bookTransaction(tx, LedgerAccountFees, &Transaction{
+		UserID:                transaction.UserID,
+		Type:                  "DEBIT",
+		Category:              CategoryFee,
+		Channel:               channel,
+		Amount:                fee,
+		Remarks:               transaction.Category + " fee",
+		ReferenceID:           transaction.ReferenceID,
+		OriginalTransactionID: &transaction.ID,
+	}, "fee charged")
+}
+
+// refundFee gives back the share of the fee that belongs to the refunded
+// part of a transaction; original.RefundedAmount must already include it.
+// The refund that completes the original takes whatever is left of the fee,
+// so rounding never keeps any of it back.
+func refundFee(tx *gorm.DB, original *Transaction, amount int64, referenceId *uuid.UUID, reason string) error {
+	var fee Transaction
+	err := tx.Where("original_transaction_id = ? AND category = ? AND status = ?", original.ID, CategoryFee, TransactionStatusSuccess).
+		First(&fee).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	feeAmount := fee.Amount * amount / original.Amount
+	if original.RefundedAmount >= original.Amount {
+		feeAmount = fee.Amount - fee.RefundedAmount
+	}
+	if feeAmount <= 0 {
+		return nil
+	}
+
+	result := tx.Model(&Transaction{}).
+		Where("id = ? AND refunded_amount + ? <= amount", fee.ID, feeAmount).
+		Update("refunded_amount", gorm.Expr("refunded_amount + ?", feeAmount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRefundExceedsAmount
+	}
+	fee.RefundedAmount += feeAmount
+
+	err = bookTransaction(tx, LedgerAccountFees, &Transaction{
+		UserID:                fee.UserID,
+		Type:                  "CREDIT",
+		Category:              CategoryRefund,
+		Channel:               fee.Channel,
+		Amount:                feeAmount,
+		Remarks:               reason,
+		ReferenceID:           referenceId,
+		OriginalTransactionID: &fee.ID,
+	}, "fee refunded")
+	if err != nil {
+		return err
+	}
+
+	if fee.RefundedAmount < fee.Amount {
+		return nil
+	}
+	return transitionTransactionStatus(tx, &fee, TransactionStatusReversed, "fee refunded")
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestCalculateFee(t *testing.T) {
+	tiered := FeeRule{
+		Category: CategoryTransfer,
+		Type:     FeeTypeTiered,
+		Tiers: []FeeTier{
+			{UpTo: 100000, Flat: 100, BasisPoints: 100},
+			{UpTo: 1000000},
+			{Flat: 2500},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		rules    []FeeRule
+		category string
+		channel  string
+		amount   int64
+		want     int64
+	}{
+		{
+			name:     "no rule",
+			category: CategoryPayment,
+			amount:   100000,
+			want:     0,
+		},
+		{
+			name:     "flat",
+			rules:    []FeeRule{{Category: CategoryPayment, Type: FeeTypeFlat, Flat: 2500}},
+			category: CategoryPayment,
+			amount:   100000,
+			want:     2500,
+		},
+		{
+			name:     "percentage",
+			rules:    []FeeRule{{Category: CategoryPayment, Type: FeeTypePercentage, BasisPoints: 150}},
+			category: CategoryPayment,
+			amount:   1000,
+			want:     15,
+		},
+		{
+			name:     "percentage rounds fractions up",
+			rules:    []FeeRule{{Category: CategoryPayment, Type: FeeTypePercentage, BasisPoints: 150}},
+			category: CategoryPayment,
+			amount:   1001,
+			want:     16,
+		},
+		{
+			name:     "raised to the minimum",
+			rules:    []FeeRule{{Category: CategoryPayment, Type: FeeTypePercentage, BasisPoints: 150, Min: 1000}},
+			category: CategoryPayment,
+			amount:   1000,
+			want:     1000,
+		},
+		{
+			name:     "capped at the maximum",
+			rules:    []FeeRule{{Category: CategoryPayment, Type: FeeTypePercentage, BasisPoints: 500, Max: 3000}},
+			category: CategoryPayment,
+			amount:   100000,
+			want:     3000,
+		},
+		{
+			name:     "first tier with flat and percentage",
+			rules:    []FeeRule{tiered},
+			category: CategoryTransfer,
+			amount:   50000,
+			want:     600,
+		},
+		{
+			name:     "top of a tier",
+			rules:    []FeeRule{tiered},
+			category: CategoryTransfer,
+			amount:   1000000,
+			want:     0,
+		},
+		{
+			name:     "open-ended tier",
+			rules:    []FeeRule{tiered},
+			category: CategoryTransfer,
+			amount:   1000001,
+			want:     2500,
+		},
+		{
+			name: "channel rule wins over the any-channel rule",
+			rules: []FeeRule{
+				{Category: CategoryTopUp, Type: FeeTypeFlat, Flat: 100},
+				{Category: CategoryTopUp, Channel: ChannelCard, Type: FeeTypeFlat, Flat: 200},
+			},
+			category: CategoryTopUp,
+			channel:  ChannelCard,
+			amount:   100000,
+			want:     200,
+		},
+		{
+			name: "any-channel rule for other channels",
+			rules: []FeeRule{
+				{Category: CategoryTopUp, Type: FeeTypeFlat, Flat: 100},
+				{Category: CategoryTopUp, Channel: ChannelCard, Type: FeeTypeFlat, Flat: 200},
+			},
+			category: CategoryTopUp,
+			channel:  ChannelRetail,
+			amount:   100000,
+			want:     100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFeeRules(t, test.rules)
+
+			if got := calculateFee(test.category, test.channel, test.amount); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChargeFeeRefusesFeeAboveCredit(t *testing.T) {
+	openTestDB(t)
+	useFeeRules(t, []FeeRule{{Category: CategoryTopUp, Channel: ChannelRetail, Type: FeeTypeFlat, Flat: 2500}})
+
+	user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateCreditTransaction(user.ID, NewTransactionRequest{
+		UserID:   user.ID,
+		Amount:   2500,
+		Category: CategoryTopUp,
+		Channel:  ChannelRetail,
+	})
+	if err != ErrFeeExceedsAmount {
+		t.Errorf("got %v, want %v", err, ErrFeeExceedsAmount)
+	}
+}
+
+func TestRefundFeeIsProportional(t *testing.T) {
+	openTestDB(t)
+	useFeeRules(t, []FeeRule{{Category: CategoryPayment, Type: FeeTypePercentage, BasisPoints: 150}})
+
+	user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateCreditTransaction(user.ID, NewTransactionRequest{UserID: user.ID, Amount: 100000, Category: CategoryTopUp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := CreateDebitTransaction(user.ID, NewTransactionRequest{UserID: user.ID, Amount: 1000, Category: CategoryPayment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Fee != 15 {
+		t.Fatalf("fee is %d, want 15", payment.Fee)
+	}
+
+	var fee Transaction
+	err = database.DB.Where("original_transaction_id = ? AND category = ?", payment.ID, CategoryFee).First(&fee).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 15 * 333 / 1000 rounds down to 4 twice; the last refund takes the 7
+	// that are left
+	refunds := []struct {
+		amount  int64
+		feeBack int64
+	}{
+		{333, 4},
+		{333, 4},
+		{334, 7},
+	}
+	for i, refund := range refunds {
+		if _, _, err := RefundTransaction(payment.ID, refund.amount, "refund"); err != nil {
+			t.Fatalf("refund %d: %v", i, err)
+		}
+
+		var feeBack int64
+		err := database.DB.Model(&Transaction{}).
+			Where("original_transaction_id = ? AND category = ?", fee.ID, CategoryRefund).
+			Order("created_at desc").
+			Limit(1).
+			Pluck("amount", &feeBack).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feeBack != refund.feeBack {
+			t.Errorf("refund %d gave back a fee of %d, want %d", i, feeBack, refund.feeBack)
+		}
+	}
+
+	if err := database.DB.First(&fee, &Transaction{ID: fee.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if fee.RefundedAmount != fee.Amount || fee.Status != TransactionStatusReversed {
+		t.Errorf("fee has %d of %d refunded with status %s, want all of it and %s", fee.RefundedAmount, fee.Amount, fee.Status, TransactionStatusReversed)
+	}
+
+	wallet, err := GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance != 100000 {
+		t.Errorf("balance is %d, want 100000", wallet.Balance)
+	}
+	assertLedgerBalanced(t)
+}
+
+// useFeeRules swaps the fee rules for the test.
+func useFeeRules(t *testing.T, rules []FeeRule) {
+	previous := feeRules
+	feeRules = rules
+	t.Cleanup(func() { feeRules = previous })
+}
+
+func assertLedgerBalanced(t *testing.T) {
+	t.Helper()
+
+	report, err := VerifyLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Balanced {
+		t.Errorf("ledger drifted: %+v", report)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+type IdempotencyKey entity.IdempotencyKey
+
+// BeginIdempotentRequest claims the key for the user. When the key was
+// already used for an identical request that has finished, the stored record
+// is returned with replay set so the caller can send the saved response.
+func BeginIdempotentRequest(userId uuid.UUID, key string, requestHash string) (*IdempotencyKey, bool, error) {
+	db := database.DB
+	now := time.Now()
+
+	if err := purgeExpiredIdempotencyKeys(now); err != nil {
+		return nil, false, err
+	}
+
+	record := &IdempotencyKey{
+		ID:          uuid.New(),
+		UserID:      userId,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(getenvDuration("IDEMPOTENCY_KEY_TTL", time.Hour*24)),
+		CreatedAt:   now,
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, false, nil
+	}
+
+	var existing IdempotencyKey
+	err := db.First(&existing, &IdempotencyKey{UserID: userId, Key: key}).Error
+	if err == gorm.ErrRecordNotFound {
+		// the other request failed and released the key in between
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, false, ErrIdempotencyKeyMismatch
+	}
+	if existing.StatusCode == 0 {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+
+	return &existing, true, nil
+}
+
+func CompleteIdempotentRequest(id uuid.UUID, statusCode int, responseBody []byte) error {
+	db := database.DB
+	return db.Model(&IdempotencyKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status_code":   statusCode,
+			"response_body": responseBody,
+		}).Error
+}
+
+// ReleaseIdempotentRequest forgets the key so the request can be retried.
+func ReleaseIdempotentRequest(id uuid.UUID) error {
+	db := database.DB
+	return db.Delete(&IdempotencyKey{ID: id}).Error
+}
+
+// purgeExpiredIdempotencyKeys drops every expired key, not just the one being
+// claimed, so keys that are never reused don't pile up.
+func purgeExpiredIdempotencyKeys(now time.Time) error {
+	db := database.DB
+	return db.Where("expires_at < ?", now).Delete(&IdempotencyKey{}).Error
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+	"gorm.io/gorm"
+)
+
+type KycSubmission entity.KycSubmission
+
+const (
+	KycTierBasic    = "BASIC"
+	KycTierVerified = "VERIFIED"
+)
+
+const (
+	KycSubmissionStatusPending  = "PENDING"
+	KycSubmissionStatusApproved = "APPROVED"
+	KycSubmissionStatusRejected = "REJECTED"
+)
+
+var (
+	ErrKycAlreadyVerified       = errors.New("account is already verified")
+	ErrKycSubmissionPending     = errors.New("a document is already waiting for review")
+	ErrKycSubmissionNotFound    = errors.New("KYC submission not found")
+	ErrKycSubmissionReviewed    = errors.New("KYC submission has already been reviewed")
+	ErrKycDocumentType          = errors.New("document must be a JPEG, PNG or PDF file")
+	ErrKycRejectionReason       = errors.New("reason is required")
+	ErrKycBalanceCapExceeded    = errors.New("balance would go above the cap of the account's verification tier")
+	ErrKycMonthlyInflowExceeded = errors.New("monthly incoming money would go above the cap of the account's verification tier")
+)
+
+// kycDocumentExtensions lists the accepted document formats by content type.
+var kycDocumentExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// KycTierCap holds what a wallet of a tier may hold and receive. A cap of 0
+// means unlimited.
+type KycTierCap struct {
+	Tier          string `json:"tier"`
+	MaxBalance    int64  `json:"max_balance"`
+	MonthlyInflow int64  `json:"monthly_inflow"`
+}
+
+type NewKycSubmissionRequest struct {
+	DocumentType   string `json:"document_type" validate:"required,oneof=KTP PASSPORT"`
+	DocumentNumber string `json:"document_number" validate:"required,max=50"`
+	FullName       string `json:"full_name" validate:"required,max=200"`
+}
+
+// KycTierCaps returns the caps of a tier, read from KYC_<TIER>_MAX_BALANCE
+// and KYC_<TIER>_MONTHLY_INFLOW.
+func KycTierCaps(tier string) KycTierCap {
+	maxBalance, monthlyInflow := 2000000, 20000000
+	if tier == KycTierVerified {
+		maxBalance, monthlyInflow = 20000000, 40000000
+	}
+
+	prefix := "KYC_" + strings.ToUpper(tier) + "_"
+	return KycTierCap{
+		Tier:          tier,
+		MaxBalance:    int64(getenvInt(prefix+"MAX_BALANCE", maxBalance)),
+		MonthlyInflow: int64(getenvInt(prefix+"MONTHLY_INFLOW", monthlyInflow)),
+	}
+}
+
+// SubmitKycDocument stores the photo of an identity document and queues it
+// for review. A user can only have one submission waiting at a time.
+func SubmitKycDocument(ctx context.Context, userId uuid.UUID, request NewKycSubmissionRequest, document io.Reader) (*KycSubmission, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+
+	// trust the file's content rather than the name or header it came with
+	head := make([]byte, 512)
+	n, err := io.ReadFull(document, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, ErrKycDocumentType
+	}
+	head = head[:n]
+	contentType := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+	extension, ok := kycDocumentExtensions[contentType]
+	if !ok {
+		return nil, ErrKycDocumentType
+	}
+
+	db := database.DB
+	var user User
+	if err := db.First(&user, &User{ID: userId}).Error; err != nil {
+		return nil, err
+	}
+	if user.KycTier == KycTierVerified {
+		return nil, ErrKycAlreadyVerified
+	}
+
+	submission := &KycSubmission{
+		ID:             uuid.New(),
+		UserID:         userId,
+		DocumentType:   request.DocumentType,
+		DocumentNumber: request.DocumentNumber,
+		FullName:       request.FullName,
+		ContentType:    contentType,
+		Status:         KycSubmissionStatusPending,
+		CreatedAt:      time.Now(),
+	}
+	submission.DocumentKey = userId.String() + "/" + submission.ID.String() + extension
+
+	if err := Documents.Save(ctx, submission.DocumentKey, io.MultiReader(bytes.NewReader(head), document)); err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var pending int64
+		err := tx.Model(&KycSubmission{}).
+			Where("user_id = ? AND status = ?", userId, KycSubmissionStatusPending).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrKycSubmissionPending
+		}
+
+		return tx.Create(submission).Error
+	})
+	if err != nil {
+		Documents.Delete(ctx, submission.DocumentKey)
+		return nil, err
+	}
+
+	return submission, nil
+}
+
+// GetKycSubmissions lists the user's submissions, newest first.
+func GetKycSubmissions(userId uuid.UUID) ([]KycSubmission, error) {
+	db := database.DB
+	submissions := []KycSubmission{}
+	err := db.Where("user_id = ?", userId).Order("created_at DESC").Find(&submissions).Error
+	return submissions, err
+}
+
+// GetKycSubmissionsForReview lists submissions with the given status, or all
+// of them, oldest first so reviewers work through the queue in order.
+func GetKycSubmissionsForReview(status string) ([]KycSubmission, error) {
+	db := database.DB
+	query := db.Order("created_at ASC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	submissions := []KycSubmission{}
+	err := query.Find(&submissions).Error
+	return submissions, err
+}
+
+// OpenKycDocument returns the submission together with its document. The
+// caller has to close the document.
+func OpenKycDocument(ctx context.Context, submissionId uuid.UUID) (*KycSubmission, io.ReadCloser, error) {
+	db := database.DB
+	var submission KycSubmission
+	err := db.First(&submission, &KycSubmission{ID: submissionId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, ErrKycSubmissionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document, err := Documents.Open(ctx, submission.DocumentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &submission, document, nil
+}
+
+// ApproveKycSubmission accepts a pending submission and moves its user to
+// the VERIFIED tier.
+func ApproveKycSubmission(submissionId uuid.UUID, reviewerId uuid.UUID) (*KycSubmission, error) {
+	return reviewKycSubmission(submissionId, reviewerId, KycSubmissionStatusApproved, "")
+}
+
+// RejectKycSubmission turns a pending submission down. The user keeps their
+// tier and may submit a new document.
+func RejectKycSubmission(submissionId uuid.UUID, reviewerId uuid.UUID, reason string) (*KycSubmission, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrKycRejectionReason
+	}
+	return reviewKycSubmission(submissionId, reviewerId, KycSubmissionStatusRejected, reason)
+}
+
+func reviewKycSubmission(submissionId uuid.UUID, reviewerId uuid.UUID, status string, reason string) (*KycSubmission, error) {
+	db := database.DB
+	var submission KycSubmission
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&submission, &KycSubmission{ID: submissionId}).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrKycSubmissionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		// only one reviewer can decide on a submission
+		result := tx.Model(&KycSubmission{}).
+			Where("id = ? AND status = ?", submission.ID, KycSubmissionStatusPending).
+			Updates(map[string]interface{}{
+				"status":           status,
+				"reviewer_id":      reviewerId,
+				"rejection_reason": reason,
+				"reviewed_at":      now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrKycSubmissionReviewed
+		}
+		submission.Status = status
+		submission.ReviewerID = &reviewerId
+		submission.RejectionReason = reason
+		submission.ReviewedAt = &now
+
+		if status != KycSubmissionStatusApproved {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", submission.UserID).Update("kyc_tier", KycTierVerified).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &submission, nil
+}
+
+// checkKycCaps makes sure money coming into the user's wallet keeps it
+// within the balance and monthly inflow caps of the user's tier. Refunds
+// only give back money that was already in the wallet and are not checked.
+func checkKycCaps(tx *gorm.DB, user *User, amount int64) error {
+	caps := KycTierCaps(user.KycTier)
+
+	if caps.MaxBalance > 0 && user.Balance+amount > caps.MaxBalance {
+		return ErrKycBalanceCapExceeded
+	}
+
+	if caps.MonthlyInflow > 0 {
+		_, monthStart := limitPeriodStarts(time.Now())
+
+		var inflow int64
+		err := tx.Model(&Transaction{}).
+			Select("COALESCE(SUM(amount), 0)").
+			Where("user_id = ? AND type = ? AND category <> ? AND created_at >= ?", user.ID, "CREDIT", CategoryRefund, monthStart.In(time.Local)).
+			Where("status NOT IN ?", []string{TransactionStatusFailed, TransactionStatusCancelled}).
+			Scan(&inflow).Error
+		if err != nil {
+			return err
+		}
+		if inflow+amount > caps.MonthlyInflow {
+			return ErrKycMonthlyInflowExceeded
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestCheckKycCaps(t *testing.T) {
+	now := time.Now()
+	_, monthStart := limitPeriodStarts(now)
+
+	type seed struct {
+		amount   int64
+		category string
+		at       time.Time
+	}
+	tests := []struct {
+		name    string
+		tier    string
+		balance int64
+		seeds   []seed
+		amount  int64
+		want    error
+	}{
+		{
+			name:    "basic balance up to the cap",
+			tier:    KycTierBasic,
+			balance: 1500000,
+			amount:  500000,
+		},
+		{
+			name:    "basic balance above the cap",
+			tier:    KycTierBasic,
+			balance: 1500000,
+			amount:  500001,
+			want:    ErrKycBalanceCapExceeded,
+		},
+		{
+			name:    "verified balance above the basic cap",
+			tier:    KycTierVerified,
+			balance: 1500000,
+			amount:  18500000,
+		},
+		{
+			name:    "verified balance above the cap",
+			tier:    KycTierVerified,
+			balance: 1500000,
+			amount:  18500001,
+			want:    ErrKycBalanceCapExceeded,
+		},
+		{
+			name:   "basic inflow above the cap",
+			tier:   KycTierBasic,
+			seeds:  []seed{{amount: 10000000, at: now}, {amount: 9500000, at: now}},
+			amount: 500001,
+			want:   ErrKycMonthlyInflowExceeded,
+		},
+		{
+			name:   "basic inflow up to the cap",
+			tier:   KycTierBasic,
+			seeds:  []seed{{amount: 10000000, at: now}, {amount: 9500000, at: now}},
+			amount: 500000,
+		},
+		{
+			name:   "verified inflow above the cap",
+			tier:   KycTierVerified,
+			seeds:  []seed{{amount: 20000000, at: now}, {amount: 19000000, at: now}},
+			amount: 1000001,
+			want:   ErrKycMonthlyInflowExceeded,
+		},
+		{
+			name:   "last month's inflow does not count",
+			tier:   KycTierBasic,
+			seeds:  []seed{{amount: 20000000, at: monthStart.Add(-time.Second)}},
+			amount: 1000000,
+		},
+		{
+			name:   "refunds do not count as inflow",
+			tier:   KycTierBasic,
+			seeds:  []seed{{amount: 20000000, category: CategoryRefund, at: now}},
+			amount: 1000000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			openTestDB(t)
+
+			user := &User{ID: uuid.New(), KycTier: test.tier, Balance: test.balance}
+			for _, seed := range test.seeds {
+				transaction := &Transaction{
+					ID:        uuid.New(),
+					UserID:    user.ID,
+					Type:      "CREDIT",
+					Category:  defaultString(seed.category, CategoryTopUp),
+					Amount:    seed.amount,
+					Status:    TransactionStatusSuccess,
+					CreatedAt: seed.at.In(time.Local),
+				}
+				if err := database.DB.Create(transaction).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := checkKycCaps(database.DB, user, test.amount); err != test.want {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSubmitKycDocumentSniffsContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		document []byte
+		want     error
+	}{
+		{name: "jpeg", document: []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")},
+		{name: "png", document: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+		{name: "pdf", document: []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")},
+		{name: "text", document: []byte("just some text"), want: ErrKycDocumentType},
+		{name: "html", document: []byte("<html><body>id</body></html>"), want: ErrKycDocumentType},
+		{name: "empty", document: []byte{}, want: ErrKycDocumentType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			openTestDB(t)
+			useDocumentStorage(t, NewLocalDocumentStorage(t.TempDir()))
+
+			user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := NewKycSubmissionRequest{DocumentType: "KTP", DocumentNumber: "3171000000000001", FullName: "Test"}
+			submission, err := SubmitKycDocument(context.Background(), user.ID, request, bytes.NewReader(test.document))
+			if err != test.want {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+			if err != nil {
+				return
+			}
+
+			// the whole file is stored, including the part read for sniffing
+			document, err := Documents.Open(context.Background(), submission.DocumentKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer document.Close()
+			stored, err := io.ReadAll(document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, test.document) {
+				t.Errorf("stored %q, want %q", stored, test.document)
+			}
+		})
+	}
+}
+
+// useDocumentStorage swaps the document storage for the test.
+func useDocumentStorage(t *testing.T, storage DocumentStorage) {
+	previous := Documents
+	Documents = storage
+	t.Cleanup(func() { Documents = previous })
+}
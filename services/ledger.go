@@ -0,0 +1,301 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LedgerAccountTypeWallet = "WALLET"
+	LedgerAccountTypeSystem = "SYSTEM"
+)
+
+// System accounts of the ledger. Money enters through top-up settlement and
+// leaves through payment settlement; transfers pass through the clearing
+// account while they are in flight.
+const (
+	LedgerAccountTopUpSettlement   = "system:topup_settlement"
+	LedgerAccountPaymentSettlement = "system:payment_settlement"
+	LedgerAccountTransferClearing  = "system:transfer_clearing"
+	LedgerAccountFees              = "system:fees"
+	LedgerAccountOpeningBalances   = "system:opening_balances"
+)
+
+var ErrUnbalancedJournal = errors.New("journal legs do not sum to zero")
+
+type (
+	LedgerAccount entity.LedgerAccount
+	JournalEntry  entity.JournalEntry
+	JournalLeg    entity.JournalLeg
+)
+
+// LedgerLine is one leg to post: Amount is added to the account balance.
+type LedgerLine struct {
+	AccountID     uuid.UUID
+	Amount        int64
+	TransactionID *uuid.UUID
+}
+
+// LedgerReport is the outcome of VerifyLedger. The books balance when all
+// of its lists are empty.
+type LedgerReport struct {
+	Balanced           bool
+	UnbalancedEntries  []uuid.UUID
+	MismatchedAccounts []string
+	MismatchedUsers    []uuid.UUID
+}
+
+// postJournal records a journal entry and applies its legs to the account
+// balances. Wallet balances are copied to User.Balance, which only caches
+// them.
+func postJournal(tx *gorm.DB, description string, lines []LedgerLine) (*JournalEntry, error) {
+	var sum int64
+	for _, line := range lines {
+		sum += line.Amount
+	}
+	if len(lines) < 2 || sum != 0 {
+		return nil, ErrUnbalancedJournal
+	}
+
+	entry := &JournalEntry{
+		ID:          uuid.New(),
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		leg := &JournalLeg{
+			ID:             uuid.New(),
+			JournalEntryID: entry.ID,
+			AccountID:      line.AccountID,
+			TransactionID:  line.TransactionID,
+			Amount:         line.Amount,
+			CreatedAt:      entry.CreatedAt,
+		}
+		if err := tx.Create(leg).Error; err != nil {
+			return nil, err
+		}
+
+		// wallets may not go below zero; checking in the UPDATE itself keeps
+		// concurrent debits from both passing a balance read earlier
+		result := tx.Model(&LedgerAccount{}).
+			Where("id = ? AND (type <> ? OR balance + ? >= 0)", line.AccountID, LedgerAccountTypeWallet, line.Amount).
+			Update("balance", gorm.Expr("balance + ?", line.Amount))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrInsufficientBalance
+		}
+
+		err := tx.Model(&User{}).
+			Where("id = (SELECT user_id FROM ledger_accounts WHERE id = ?)", line.AccountID).
+			Update("balance", gorm.Expr("(SELECT balance FROM ledger_accounts WHERE id = ?)", line.AccountID)).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+// postWalletJournal moves the transaction's amount between the user's wallet
+// and a counter account: out of the wallet for a DEBIT, into it for a CREDIT.
+func postWalletJournal(tx *gorm.DB, transaction *Transaction, counterAccountCode string) error {
+	wallet, err := walletAccount(tx, transaction.UserID)
+	if err != nil {
+		return err
+	}
+	counterAccount, err := systemAccount(tx, counterAccountCode)
+	if err != nil {
+		return err
+	}
+
+	amount := transaction.Amount
+	if transaction.Type == "DEBIT" {
+		amount = -amount
+	}
+
+	_, err = postJournal(tx, transaction.Category+" "+transaction.Type, []LedgerLine{
+		{AccountID: wallet.ID, Amount: amount, TransactionID: &transaction.ID},
+		{AccountID: counterAccount.ID, Amount: -amount, TransactionID: &transaction.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the balance read by the caller may be stale, so record the one the
+	// posting actually produced
+	if err := tx.First(wallet, &LedgerAccount{ID: wallet.ID}).Error; err != nil {
+		return err
+	}
+	transaction.BalanceBefore = wallet.Balance - amount
+	transaction.BalanceAfter = wallet.Balance
+	return tx.Model(&Transaction{}).
+		Where("id = ?", transaction.ID).
+		Updates(map[string]interface{}{
+			"balance_before": transaction.BalanceBefore,
+			"balance_after":  transaction.BalanceAfter,
+		}).Error
+}
+
+// counterAccountCode is the system account on the other side of wallet
+// postings of the category.
+func counterAccountCode(category string) string {
+	switch category {
+	case CategoryTopUp:
+		return LedgerAccountTopUpSettlement
+	case CategoryPayment:
+		return LedgerAccountPaymentSettlement
+	}
+	return LedgerAccountTransferClearing
+}
+
+// walletAccount returns the user's wallet account, opening it on first use.
+// A balance the user had before the ledger existed is brought in with an
+// opening-balance journal.
+func walletAccount(tx *gorm.DB, userId uuid.UUID) (*LedgerAccount, error) {
+	var account LedgerAccount
+	err := tx.First(&account, &LedgerAccount{UserID: &userId}).Error
+	if err == nil {
+		return &account, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var user User
+	if err := tx.First(&user, &User{ID: userId}).Error; err != nil {
+		return nil, err
+	}
+
+	account = LedgerAccount{
+		ID:        uuid.New(),
+		Code:      "wallet:" + userId.String(),
+		Type:      LedgerAccountTypeWallet,
+		UserID:    &userId,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return nil, err
+	}
+
+	if user.Balance != 0 {
+		openingBalances, err := systemAccount(tx, LedgerAccountOpeningBalances)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = postJournal(tx, "opening balance", []LedgerLine{
+			{AccountID: account.ID, Amount: user.Balance},
+			{AccountID: openingBalances.ID, Amount: -user.Balance},
+		})
+		if err != nil {
+			return nil, err
+		}
+		account.Balance = user.Balance
+	}
+
+	return &account, nil
+}
+
+// systemAccount returns the system account with the given code, opening it
+// on first use.
+func systemAccount(tx *gorm.DB, code string) (*LedgerAccount, error) {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LedgerAccount{
+		ID:        uuid.New(),
+		Code:      code,
+		Type:      LedgerAccountTypeSystem,
+		CreatedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var account LedgerAccount
+	err = tx.First(&account, &LedgerAccount{Code: code}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// OpenWalletAccounts brings the balances of users that have no wallet
+// account yet, i.e. balances from before the ledger, into the books.
+func OpenWalletAccounts() error {
+	db := database.DB
+	userIds := []uuid.UUID{}
+	err := db.Model(&User{}).
+		Where("balance <> 0 AND id NOT IN (SELECT user_id FROM ledger_accounts WHERE user_id IS NOT NULL)").
+		Pluck("id", &userIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range userIds {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := walletAccount(tx, userId)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetSystemLedgerAccounts() ([]LedgerAccount, error) {
+	db := database.DB
+	accounts := []LedgerAccount{}
+	err := db.Where("type = ?", LedgerAccountTypeSystem).Order("code asc").Find(&accounts).Error
+	return accounts, err
+}
+
+// VerifyLedger checks that every journal entry sums to zero, that each
+// account balance equals the sum of its legs and that every user's cached
+// balance matches their wallet.
+func VerifyLedger() (*LedgerReport, error) {
+	db := database.DB
+	report := &LedgerReport{
+		UnbalancedEntries:  []uuid.UUID{},
+		MismatchedAccounts: []string{},
+		MismatchedUsers:    []uuid.UUID{},
+	}
+
+	err := db.Model(&JournalLeg{}).
+		Group("journal_entry_id").
+		Having("SUM(amount) <> 0").
+		Pluck("journal_entry_id", &report.UnbalancedEntries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&LedgerAccount{}).
+		Where("balance <> (SELECT COALESCE(SUM(amount), 0) FROM journal_legs WHERE account_id = ledger_accounts.id)").
+		Pluck("code", &report.MismatchedAccounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&User{}).
+		Where("balance <> COALESCE((SELECT balance FROM ledger_accounts WHERE user_id = users.id), 0)").
+		Pluck("id", &report.MismatchedUsers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report.Balanced = len(report.UnbalancedEntries) == 0 &&
+		len(report.MismatchedAccounts) == 0 &&
+		len(report.MismatchedUsers) == 0
+	return report, nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"gorm.io/gorm"
+)
+
+const (
+	LimitPeriodTransaction = "TRANSACTION"
+	LimitPeriodDaily       = "DAILY"
+	LimitPeriodMonthly     = "MONTHLY"
+)
+
+// TransactionLimit caps the money a user can move in one category and
+// direction. A limit of 0 means unlimited.
+type TransactionLimit struct {
+	Category       string `json:"category"`
+	Type           string `json:"type"`
+	PerTransaction int64  `json:"per_transaction"`
+	Daily          int64  `json:"daily"`
+	Monthly        int64  `json:"monthly"`
+}
+
+// LimitUsage is a limit together with what the user has used of it in the
+// current day and month.
+type LimitUsage struct {
+	TransactionLimit
+	UsedToday        int64     `json:"used_today"`
+	UsedThisMonth    int64     `json:"used_this_month"`
+	RemainingToday   *int64    `json:"remaining_today"`
+	RemainingMonthly *int64    `json:"remaining_monthly"`
+	DailyResetAt     time.Time `json:"daily_reset_at"`
+	MonthlyResetAt   time.Time `json:"monthly_reset_at"`
+}
+
+type TransactionLimitExceededError struct {
+	Category  string
+	Period    string
+	Limit     int64
+	Remaining int64
+}
+
+func (e *TransactionLimitExceededError) Error() string {
+	if e.Period == LimitPeriodTransaction {
+		return fmt.Sprintf("%s amount is above the limit of %d per transaction", e.Category, e.Limit)
+	}
+	return fmt.Sprintf("%s %s limit of %d exceeded, %d remaining", e.Category, e.Period, e.Limit, e.Remaining)
+}
+
+// TransactionLimits returns the limits for every category that has one.
+// They are read from LIMIT_<CATEGORY>_PER_TRANSACTION, _DAILY and _MONTHLY.
+func TransactionLimits() []TransactionLimit {
+	return []TransactionLimit{
+		transactionLimit(CategoryTopUp, "CREDIT", 10000000, 20000000, 40000000),
+		transactionLimit(CategoryPayment, "DEBIT", 10000000, 20000000, 100000000),
+		transactionLimit(CategoryTransfer, "DEBIT", 10000000, 20000000, 100000000),
+	}
+}
+
+func transactionLimit(category string, transactionType string, perTransaction int64, daily int64, monthly int64) TransactionLimit {
+	prefix := "LIMIT_" + strings.ToUpper(category) + "_"
+	return TransactionLimit{
+		Category:       category,
+		Type:           transactionType,
+		PerTransaction: int64(getenvInt(prefix+"PER_TRANSACTION", int(perTransaction))),
+		Daily:          int64(getenvInt(prefix+"DAILY", int(daily))),
+		Monthly:        int64(getenvInt(prefix+"MONTHLY", int(monthly))),
+	}
+}
+
+// GetLimitUsage reports the limits of the user with what is left of them.
+func GetLimitUsage(userId uuid.UUID) ([]LimitUsage, error) {
+	db := database.DB
+	now := time.Now()
+	dayStart, monthStart := limitPeriodStarts(now)
+
+	usages := []LimitUsage{}
+	for _, limit := range TransactionLimits() {
+		usage := LimitUsage{
+			TransactionLimit: limit,
+			DailyResetAt:     dayStart.AddDate(0, 0, 1),
+			MonthlyResetAt:   monthStart.AddDate(0, 1, 0),
+		}
+
+		var err error
+		usage.UsedToday, err = limitUsage(db, userId, limit, dayStart)
+		if err != nil {
+			return nil, err
+		}
+		usage.UsedThisMonth, err = limitUsage(db, userId, limit, monthStart)
+		if err != nil {
+			return nil, err
+		}
+
+		if limit.Daily > 0 {
+			remaining := max(limit.Daily-usage.UsedToday, 0)
+			usage.RemainingToday = &remaining
+		}
+		if limit.Monthly > 0 {
+			remaining := max(limit.Monthly-usage.UsedThisMonth, 0)
+			usage.RemainingMonthly = &remaining
+		}
+
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
+
+// checkTransactionLimits makes sure a new transaction stays within the
+// limits of its category. It must run in the same DB transaction that
+// stores it; writers are serialized, so concurrent requests can't both use
+// the last of an allowance.
+func checkTransactionLimits(tx *gorm.DB, userId uuid.UUID, transactionType string, category string, amount int64) error {
+	for _, limit := range TransactionLimits() {
+		if limit.Category != category || limit.Type != transactionType {
+			continue
+		}
+
+		if limit.PerTransaction > 0 && amount > limit.PerTransaction {
+			return &TransactionLimitExceededError{
+				Category: category,
+				Period:   LimitPeriodTransaction,
+				Limit:    limit.PerTransaction,
+			}
+		}
+
+		dayStart, monthStart := limitPeriodStarts(time.Now())
+		periods := []struct {
+			name  string
+			limit int64
+			since time.Time
+		}{
+			{LimitPeriodDaily, limit.Daily, dayStart},
+			{LimitPeriodMonthly, limit.Monthly, monthStart},
+		}
+		for _, period := range periods {
+			if period.limit <= 0 {
+				continue
+			}
+			used, err := limitUsage(tx, userId, limit, period.since)
+			if err != nil {
+				return err
+			}
+			if used+amount > period.limit {
+				return &TransactionLimitExceededError{
+					Category:  category,
+					Period:    period.name,
+					Limit:     period.limit,
+					Remaining: max(period.limit-used, 0),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// limitUsage sums what the user moved in the limit's category since the
+// given time. Failed, cancelled and reversed transactions don't count, and
+// neither does the refunded part of a transaction.
+func limitUsage(tx *gorm.DB, userId uuid.UUID, limit TransactionLimit, since time.Time) (int64, error) {
+	// created_at is stored as text in server time, so compare in that zone
+	since = since.In(time.Local)
+
+	var used int64
+	err := tx.Model(&Transaction{}).
+		Select("COALESCE(SUM(amount - refunded_amount), 0)").
+		Where("user_id = ? AND category = ? AND type = ? AND created_at >= ?", userId, limit.Category, limit.Type, since).
+		Where("status NOT IN ?", []string{TransactionStatusFailed, TransactionStatusCancelled, TransactionStatusReversed}).
+		Scan(&used).Error
+	return used, err
+}
+
+// limitPeriodStarts returns the start of the current day and month in the
+// LIMIT_RESET_TIMEZONE time zone (the server's by default).
+func limitPeriodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.In(limitLocation())
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return dayStart, monthStart
+}
+
+func limitLocation() *time.Location {
+	name := os.Getenv("LIMIT_RESET_TIMEZONE")
+	if name == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("invalid LIMIT_RESET_TIMEZONE %q: %v", name, err)
+		return time.Local
+	}
+	return location
+}
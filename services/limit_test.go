@@ -0,0 +1,199 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestCheckTransactionLimits(t *testing.T) {
+	dayStart, monthStart := limitPeriodStarts(time.Now())
+	now := time.Now()
+
+	type seed struct {
+		amount   int64
+		refunded int64
+		status   string
+		at       time.Time
+	}
+	tests := []struct {
+		name      string
+		daily     string
+		monthly   string
+		seeds     []seed
+		amount    int64
+		period    string
+		remaining int64
+	}{
+		{
+			name:   "above the per-transaction limit",
+			amount: 1001,
+			period: LimitPeriodTransaction,
+		},
+		{
+			name:   "at the per-transaction limit",
+			amount: 1000,
+		},
+		{
+			name:      "daily limit used up today",
+			daily:     "3000",
+			seeds:     []seed{{amount: 1000, at: now}, {amount: 1000, at: now}, {amount: 500, at: now}},
+			amount:    600,
+			period:    LimitPeriodDaily,
+			remaining: 500,
+		},
+		{
+			name:   "rest of the daily limit",
+			daily:  "3000",
+			seeds:  []seed{{amount: 1000, at: now}, {amount: 1000, at: now}, {amount: 500, at: now}},
+			amount: 500,
+		},
+		{
+			name:   "yesterday does not count for the daily limit",
+			daily:  "3000",
+			seeds:  []seed{{amount: 1000, at: dayStart.Add(-time.Second)}, {amount: 1000, at: dayStart.Add(-time.Second)}, {amount: 1000, at: now}},
+			amount: 1000,
+		},
+		{
+			name:      "monthly limit used up this month",
+			monthly:   "5000",
+			seeds:     []seed{{amount: 1000, at: monthStart}, {amount: 1000, at: monthStart}, {amount: 1000, at: monthStart}, {amount: 1000, at: monthStart}, {amount: 500, at: now}},
+			amount:    600,
+			period:    LimitPeriodMonthly,
+			remaining: 500,
+		},
+		{
+			name:    "last month does not count for the monthly limit",
+			monthly: "5000",
+			seeds:   []seed{{amount: 1000, at: monthStart.Add(-time.Second)}, {amount: 1000, at: monthStart.Add(-time.Second)}, {amount: 1000, at: now}, {amount: 1000, at: now}, {amount: 1000, at: now}},
+			amount:  1000,
+		},
+		{
+			name:  "failed, cancelled and reversed payments don't count",
+			daily: "1000",
+			seeds: []seed{
+				{amount: 1000, status: TransactionStatusFailed, at: now},
+				{amount: 1000, status: TransactionStatusCancelled, at: now},
+				{amount: 1000, refunded: 1000, status: TransactionStatusReversed, at: now},
+			},
+			amount: 1000,
+		},
+		{
+			name:      "the refunded part of a payment doesn't count",
+			daily:     "1000",
+			seeds:     []seed{{amount: 1000, refunded: 400, at: now}},
+			amount:    500,
+			period:    LimitPeriodDaily,
+			remaining: 400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			openTestDB(t)
+			t.Setenv("LIMIT_PAYMENT_PER_TRANSACTION", "1000")
+			t.Setenv("LIMIT_PAYMENT_DAILY", defaultString(test.daily, "0"))
+			t.Setenv("LIMIT_PAYMENT_MONTHLY", defaultString(test.monthly, "0"))
+
+			userId := uuid.New()
+			for _, seed := range test.seeds {
+				transaction := &Transaction{
+					ID:             uuid.New(),
+					UserID:         userId,
+					Type:           "DEBIT",
+					Category:       CategoryPayment,
+					Amount:         seed.amount,
+					RefundedAmount: seed.refunded,
+					Status:         defaultString(seed.status, TransactionStatusSuccess),
+					CreatedAt:      seed.at.In(time.Local),
+				}
+				if err := database.DB.Create(transaction).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := checkTransactionLimits(database.DB, userId, "DEBIT", CategoryPayment, test.amount)
+			if test.period == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+
+			exceeded, ok := err.(*TransactionLimitExceededError)
+			if !ok {
+				t.Fatalf("got %v, want a %s limit error", err, test.period)
+			}
+			if exceeded.Period != test.period || exceeded.Remaining != test.remaining {
+				t.Errorf("got %s limit with %d remaining, want %s with %d", exceeded.Period, exceeded.Remaining, test.period, test.remaining)
+			}
+		})
+	}
+}
+
+func TestLimitPeriodStarts(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name       string
+		timezone   string
+		now        time.Time
+		dayStart   time.Time
+		monthStart time.Time
+	}{
+		{
+			name:       "still the last day of the month in Jakarta",
+			timezone:   "Asia/Jakarta",
+			now:        time.Date(2024, time.March, 31, 16, 59, 59, 0, time.UTC),
+			dayStart:   time.Date(2024, time.March, 31, 0, 0, 0, 0, jakarta),
+			monthStart: time.Date(2024, time.March, 1, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:       "already the next month in Jakarta",
+			timezone:   "Asia/Jakarta",
+			now:        time.Date(2024, time.March, 31, 17, 0, 0, 0, time.UTC),
+			dayStart:   time.Date(2024, time.April, 1, 0, 0, 0, 0, jakarta),
+			monthStart: time.Date(2024, time.April, 1, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:       "UTC",
+			timezone:   "UTC",
+			now:        time.Date(2024, time.March, 31, 17, 0, 0, 0, time.UTC),
+			dayStart:   time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC),
+			monthStart: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "an unknown zone falls back to server time",
+			timezone:   "Nowhere/Special",
+			now:        time.Date(2024, time.March, 31, 17, 0, 0, 0, time.Local),
+			dayStart:   time.Date(2024, time.March, 31, 0, 0, 0, 0, time.Local),
+			monthStart: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("LIMIT_RESET_TIMEZONE", test.timezone)
+
+			dayStart, monthStart := limitPeriodStarts(test.now)
+			if !dayStart.Equal(test.dayStart) {
+				t.Errorf("day starts at %v, want %v", dayStart, test.dayStart)
+			}
+			if !monthStart.Equal(test.monthStart) {
+				t.Errorf("month starts at %v, want %v", monthStart, test.monthStart)
+			}
+		})
+	}
+}
+
+func defaultString(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+const maxLoginDelay = time.Minute
+
+type LoginAttempt entity.LoginAttempt
+
+// LoginBlockedError is returned while a phone number or IP has to wait before
+// trying another PIN. Locked is set once the failure limit has been reached.
+type LoginBlockedError struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("login is locked, retry after %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// CheckLoginAllowed returns a *LoginBlockedError when either the phone number
+// or the client IP is locked or still inside its progressive delay.
+func CheckLoginAllowed(phoneNumber string, ip string) error {
+	db := database.DB
+	now := time.Now()
+
+	for _, key := range loginAttemptKeys(phoneNumber, ip) {
+		var attempt LoginAttempt
+		err := db.First(&attempt, &LoginAttempt{Key: key.name}).Error
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			return &LoginBlockedError{Locked: true, RetryAfter: attempt.LockedUntil.Sub(now)}
+		}
+
+		if !key.progressive {
+			continue
+		}
+
+		allowedAt := attempt.LastFailureAt.Add(loginDelay(attempt.Failures))
+		if attempt.LockedUntil == nil && now.Before(allowedAt) {
+			return &LoginBlockedError{RetryAfter: allowedAt.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// RecordLoginFailure counts a failed PIN attempt and locks the phone number
+// or IP once it reaches its limit.
+func RecordLoginFailure(phoneNumber string, ip string) error {
+	db := database.DB
+	lockoutDuration := getenvDuration("LOGIN_LOCKOUT_DURATION", time.Minute*15)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, key := range loginAttemptKeys(phoneNumber, ip) {
+			attempt := LoginAttempt{Key: key.name}
+			err := tx.First(&attempt, &LoginAttempt{Key: key.name}).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+
+			// start over once a lockout has been served or the last failure is old
+			expired := attempt.LockedUntil != nil && now.After(*attempt.LockedUntil)
+			stale := now.Sub(attempt.LastFailureAt) > lockoutDuration
+			if expired || stale {
+				attempt.Failures = 0
+				attempt.LockedUntil = nil
+			}
+
+			attempt.Failures++
+			attempt.LastFailureAt = now
+			if attempt.Failures >= key.maxAttempts {
+				lockedUntil := now.Add(lockoutDuration)
+				attempt.LockedUntil = &lockedUntil
+			}
+
+			if err := tx.Save(&attempt).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ResetLoginFailures clears the counter of a phone number after a successful
+// login. The IP counter is left alone so one valid account cannot be used to
+// reset the throttle of an IP that is guessing other accounts.
+func ResetLoginFailures(phoneNumber string) error {
+	db := database.DB
+	return db.Delete(&LoginAttempt{Key: "phone:" + phoneNumber}).Error
+}
+
+// UnlockLogin lifts a lockout on a phone number before its cooldown ends.
+// It only clears the phone number; a locked IP is lifted with UnlockLoginIP.
+func UnlockLogin(phoneNumber string) error {
+	return ResetLoginFailures(phoneNumber)
+}
+
+// UnlockLoginIP lifts a lockout on a client IP, e.g. a shared NAT address
+// that got locked by a few customers mistyping their PIN.
+func UnlockLoginIP(ip string) error {
+	db := database.DB
+	return db.Delete(&LoginAttempt{Key: "ip:" + ip}).Error
+}
+
+type loginAttemptKey struct {
+	name        string
+	maxAttempts int
+	progressive bool
+}
+
+func loginAttemptKeys(phoneNumber string, ip string) []loginAttemptKey {
+	maxAttempts := getenvInt("LOGIN_MAX_ATTEMPTS", 5)
+	return []loginAttemptKey{
+		{name: "phone:" + phoneNumber, maxAttempts: maxAttempts, progressive: true},
+		// many customers can share one NAT address, so an IP only gets a
+		// higher hard limit and no per-attempt delay
+		{name: "ip:" + ip, maxAttempts: getenvInt("LOGIN_MAX_ATTEMPTS_PER_IP", maxAttempts*4)},
+	}
+}
+
+// loginDelay is the wait enforced after the given number of consecutive
+// failures: none for the first two, then 1s, 2s, 4s... capped at a minute.
+func loginDelay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+
+	delay := time.Second << (failures - 3)
+	if delay > maxLoginDelay || delay <= 0 {
+		return maxLoginDelay
+	}
+	return delay
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+const (
+	OneTimeCodePurposeRegister = "REGISTER"
+	OneTimeCodePurposeResetPin = "RESET_PIN"
+
+	oneTimeCodeMaxAttempts = 5
+)
+
+var (
+	ErrOneTimeCodeInvalid = errors.New("one-time code is invalid")
+	ErrOneTimeCodeExpired = errors.New("one-time code is expired")
+)
+
+type OneTimeCode entity.OneTimeCode
+
+// OneTimeCodeThrottledError is returned when a code was requested too soon
+// after the previous one or too often within an hour.
+type OneTimeCodeThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OneTimeCodeThrottledError) Error() string {
+	return fmt.Sprintf("one-time code was requested too often, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// SendOneTimeCode generates a 6-digit code for the phone number and purpose,
+// replacing any code that is still outstanding, and sends it by SMS.
+func SendOneTimeCode(ctx context.Context, phoneNumber string, purpose string) error {
+	db := database.DB
+	ttl := getenvDuration("OTP_TTL", time.Minute*5)
+	code, err := generateOneTimeCode()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := checkOneTimeCodeThrottle(tx, phoneNumber, purpose, now); err != nil {
+			return err
+		}
+
+		err := tx.Model(&OneTimeCode{}).
+			Where("phone_number = ? AND purpose = ? AND consumed_at IS NULL", phoneNumber, purpose).
+			Update("consumed_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&OneTimeCode{
+			ID:          uuid.New(),
+			PhoneNumber: phoneNumber,
+			Purpose:     purpose,
+			CodeHash:    hashAndSalt([]byte(code)),
+			ExpiresAt:   now.Add(ttl),
+			CreatedAt:   now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(ttl.Minutes()))
+	return SMS.Send(ctx, phoneNumber, message)
+}
+
+// VerifyOneTimeCode consumes the outstanding code for the phone number and
+// purpose. A code is burnt after too many wrong guesses.
+func VerifyOneTimeCode(phoneNumber string, purpose string, code string) error {
+	db := database.DB
+	var oneTimeCode OneTimeCode
+	err := db.Where("phone_number = ? AND purpose = ? AND consumed_at IS NULL", phoneNumber, purpose).
+		Order("created_at desc").
+		First(&oneTimeCode).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrOneTimeCodeInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(oneTimeCode.ExpiresAt) {
+		return ErrOneTimeCodeExpired
+	}
+
+	// take an attempt before comparing, so parallel guesses can't get more
+	// comparisons than the code allows
+	result := db.Model(&OneTimeCode{}).
+		Where("id = ? AND consumed_at IS NULL AND attempts < ?", oneTimeCode.ID, oneTimeCodeMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOneTimeCodeInvalid
+	}
+
+	if err := CompareHash(oneTimeCode.CodeHash, code); err != nil {
+		err := db.Model(&OneTimeCode{}).
+			Where("id = ? AND consumed_at IS NULL AND attempts >= ?", oneTimeCode.ID, oneTimeCodeMaxAttempts).
+			Update("consumed_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return ErrOneTimeCodeInvalid
+	}
+
+	result = db.Model(&OneTimeCode{}).
+		Where("id = ? AND consumed_at IS NULL", oneTimeCode.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOneTimeCodeInvalid
+	}
+
+	return nil
+}
+
+func checkOneTimeCodeThrottle(tx *gorm.DB, phoneNumber string, purpose string, now time.Time) error {
+	resendInterval := getenvDuration("OTP_RESEND_INTERVAL", time.Minute)
+	maxPerHour := getenvInt("OTP_MAX_SENDS_PER_HOUR", 5)
+
+	var recent []OneTimeCode
+	err := tx.Where("phone_number = ? AND purpose = ? AND created_at > ?", phoneNumber, purpose, now.Add(-time.Hour)).
+		Order("created_at desc").
+		Find(&recent).Error
+	if err != nil {
+		return err
+	}
+
+	if len(recent) > 0 {
+		nextAllowed := recent[0].CreatedAt.Add(resendInterval)
+		if now.Before(nextAllowed) {
+			return &OneTimeCodeThrottledError{RetryAfter: nextAllowed.Sub(now)}
+		}
+	}
+
+	if len(recent) >= maxPerHour {
+		oldest := recent[len(recent)-1]
+		return &OneTimeCodeThrottledError{RetryAfter: oldest.CreatedAt.Add(time.Hour).Sub(now)}
+	}
+
+	return nil
+}
+
+func generateOneTimeCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestVerifyOneTimeCodeConcurrentWrongGuessesBurnTheCode(t *testing.T) {
+	openTestDB(t)
+	oneTimeCode := createTestOneTimeCode(t, "081200000001", "123456")
+
+	results := verifyOneTimeCodeConcurrently(oneTimeCode, "000000", 20)
+	for err := range results {
+		if err != ErrOneTimeCodeInvalid {
+			t.Errorf("wrong guess returned %v, want %v", err, ErrOneTimeCodeInvalid)
+		}
+	}
+
+	var saved OneTimeCode
+	if err := database.DB.First(&saved, &OneTimeCode{ID: oneTimeCode.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.Attempts != oneTimeCodeMaxAttempts {
+		t.Errorf("attempts is %d, want %d", saved.Attempts, oneTimeCodeMaxAttempts)
+	}
+	if saved.ConsumedAt == nil {
+		t.Error("code was not burnt")
+	}
+
+	err := VerifyOneTimeCode(oneTimeCode.PhoneNumber, oneTimeCode.Purpose, "123456")
+	if err != ErrOneTimeCodeInvalid {
+		t.Errorf("right code after burning returned %v, want %v", err, ErrOneTimeCodeInvalid)
+	}
+}
+
+func TestVerifyOneTimeCodeConcurrentRightGuessesConsumeOnce(t *testing.T) {
+	openTestDB(t)
+	oneTimeCode := createTestOneTimeCode(t, "081200000001", "123456")
+
+	succeeded := 0
+	for err := range verifyOneTimeCodeConcurrently(oneTimeCode, "123456", 10) {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrOneTimeCodeInvalid:
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d verifications succeeded, want 1", succeeded)
+	}
+}
+
+func TestVerifyOneTimeCodeWrongGuessesDoNotReviveAConsumedCode(t *testing.T) {
+	openTestDB(t)
+	oneTimeCode := createTestOneTimeCode(t, "081200000001", "123456")
+
+	// one right guess among as many wrong ones as the code allows
+	start := make(chan struct{})
+	errs := make(chan error, oneTimeCodeMaxAttempts)
+	var wg sync.WaitGroup
+	for i := 0; i < oneTimeCodeMaxAttempts; i++ {
+		code := "000000"
+		if i == 0 {
+			code = "123456"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- VerifyOneTimeCode(oneTimeCode.PhoneNumber, oneTimeCode.Purpose, code)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d verifications succeeded, want 1", succeeded)
+	}
+
+	err := VerifyOneTimeCode(oneTimeCode.PhoneNumber, oneTimeCode.Purpose, "123456")
+	if err != ErrOneTimeCodeInvalid {
+		t.Errorf("reusing the code returned %v, want %v", err, ErrOneTimeCodeInvalid)
+	}
+}
+
+func verifyOneTimeCodeConcurrently(oneTimeCode *OneTimeCode, code string, requests int) chan error {
+	start := make(chan struct{})
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- VerifyOneTimeCode(oneTimeCode.PhoneNumber, oneTimeCode.Purpose, code)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	return errs
+}
+
+func createTestOneTimeCode(t *testing.T, phoneNumber string, code string) *OneTimeCode {
+	t.Helper()
+
+	now := time.Now()
+	oneTimeCode := &OneTimeCode{
+		ID:          uuid.New(),
+		PhoneNumber: phoneNumber,
+		Purpose:     OneTimeCodePurposeResetPin,
+		CodeHash:    hashAndSalt([]byte(code)),
+		ExpiresAt:   now.Add(time.Minute * 5),
+		CreatedAt:   now,
+	}
+	if err := database.DB.Create(oneTimeCode).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return oneTimeCode
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRecipientNotFound = errors.New("recipient not found")
+	ErrSelfTransfer      = errors.New("you cannot transfer to yourself")
+	ErrHandleTaken       = errors.New("handle is already taken")
+)
+
+type RecipientLookup entity.RecipientLookup
+
+// RecipientLookupThrottledError is returned when a user looked up too many
+// recipients within an hour.
+type RecipientLookupThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RecipientLookupThrottledError) Error() string {
+	return fmt.Sprintf("recipients were looked up too often, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// LookUpRecipient resolves the recipient a user typed in, for a preview or a
+// transfer. Every lookup, found or not, counts towards
+// RECIPIENT_LOOKUP_MAX_PER_HOUR so none of them can be used to walk through
+// phone numbers and handles.
+func LookUpRecipient(senderId uuid.UUID, identifier string) (*User, error) {
+	db := database.DB
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := checkRecipientLookupThrottle(tx, senderId, now); err != nil {
+			return err
+		}
+
+		return tx.Create(&RecipientLookup{
+			ID:        uuid.New(),
+			UserID:    senderId,
+			CreatedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveRecipient(senderId, identifier)
+}
+
+// resolveRecipient finds the user a transfer is addressed to. The identifier
+// may be a user ID, a phone number or a handle, with or without a leading @.
+// Handles start with a letter, so they never look like a phone number.
+func resolveRecipient(senderId uuid.UUID, identifier string) (*User, error) {
+	db := database.DB
+	identifier = strings.TrimSpace(identifier)
+
+	query := db.Model(&User{})
+	if id, err := uuid.Parse(identifier); err == nil {
+		query = query.Where("id = ?", id)
+	} else if validation.IsPhoneNumber(identifier) {
+		query = query.Where("phone_number = ?", identifier)
+	} else {
+		query = query.Where("handle = ?", NormalizeHandle(identifier))
+	}
+
+	var recipient User
+	err := query.First(&recipient).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrRecipientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recipient.ID == senderId {
+		return nil, ErrSelfTransfer
+	}
+
+	return &recipient, nil
+}
+
+// NormalizeHandle lower-cases a handle and drops the leading @ so lookups
+// are case-insensitive.
+func NormalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+}
+
+// MaskName keeps the first letter of every word of the name, e.g.
+// "Bob Doe" becomes "B** D**", so senders can check the recipient without
+// learning their full name.
+func MaskName(firstName string, lastName string) string {
+	words := strings.Fields(firstName + " " + lastName)
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// checkRecipient loads the recipient of a transfer inside tx and makes sure
+// it is somebody else who can receive money.
+func checkRecipient(tx *gorm.DB, senderId uuid.UUID, recipientId uuid.UUID) (*User, error) {
+	if recipientId == senderId {
+		return nil, ErrSelfTransfer
+	}
+
+	var recipient User
+	err := tx.First(&recipient, &User{ID: recipientId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrRecipientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanReceive(&recipient); err != nil {
+		return nil, err
+	}
+
+	return &recipient, nil
+}
+
+func checkRecipientLookupThrottle(tx *gorm.DB, senderId uuid.UUID, now time.Time) error {
+	maxPerHour := getenvInt("RECIPIENT_LOOKUP_MAX_PER_HOUR", 30)
+
+	// lookups older than the window no longer count for anybody
+	err := tx.Where("created_at <= ?", now.Add(-time.Hour)).Delete(&RecipientLookup{}).Error
+	if err != nil {
+		return err
+	}
+
+	var recent []RecipientLookup
+	err = tx.Where("user_id = ?", senderId).
+		Order("created_at asc").
+		Find(&recent).Error
+	if err != nil {
+		return err
+	}
+
+	if len(recent) >= maxPerHour {
+		oldest := recent[len(recent)-maxPerHour]
+		return &RecipientLookupThrottledError{RetryAfter: oldest.CreatedAt.Add(time.Hour).Sub(now)}
+	}
+
+	return nil
+}
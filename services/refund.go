@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTransactionNotRefundable = errors.New("only successful payments and transfers can be refunded")
+	ErrRefundExceedsAmount      = errors.New("refund exceeds the amount left to refund")
+)
+
+// RefundTransaction gives back part or all (amount 0) of a successful
+// payment or transfer as a CREDIT to the payer that points at the original.
+// For a transfer the money is taken back from the recipient with a matching
+// DEBIT. Once the whole amount has been refunded the original is REVERSED.
+func RefundTransaction(transactionId uuid.UUID, amount int64, reason string) (*Transaction, *Transaction, error) {
+	db := database.DB
+	var original Transaction
+	var refund *Transaction
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&original, &Transaction{ID: transactionId}).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if original.Type != "DEBIT" || original.Status != TransactionStatusSuccess {
+			return ErrTransactionNotRefundable
+		}
+		if original.Category != CategoryPayment && original.Category != CategoryTransfer {
+			return ErrTransactionNotRefundable
+		}
+
+		remaining := original.Amount - original.RefundedAmount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 || amount > remaining {
+			return ErrRefundExceedsAmount
+		}
+
+		// the condition keeps concurrent refunds from going over the amount
+		result := tx.Model(&Transaction{}).
+			Where("id = ? AND refunded_amount + ? <= amount", original.ID, amount).
+			Update("refunded_amount", gorm.Expr("refunded_amount + ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefundExceedsAmount
+		}
+		original.RefundedAmount += amount
+
+		referenceId := uuid.New()
+		var recipientLeg *Transaction
+		if original.Category == CategoryTransfer {
+			recipientLeg, err = findTransferCreditLeg(tx, &original)
+			if err != nil {
+				return err
+			}
+
+			err = bookTransaction(tx, LedgerAccountTransferClearing, &Transaction{
+				UserID:                recipientLeg.UserID,
+				Type:                  "DEBIT",
+				Category:              CategoryRefund,
+				Amount:                amount,
+				Remarks:               reason,
+				CorrespondingUserID:   &original.UserID,
+				ReferenceID:           &referenceId,
+				OriginalTransactionID: &recipientLeg.ID,
+			}, "refund")
+			if err != nil {
+				return err
+			}
+		}
+
+		var payer User
+		if err := tx.First(&payer, &User{ID: original.UserID}).Error; err != nil {
+			return err
+		}
+		if err := checkCanReceive(&payer); err != nil {
+			return err
+		}
+
+		refund = &Transaction{
+			UserID:                original.UserID,
+			Type:                  "CREDIT",
+			Category:              CategoryRefund,
+			Amount:                amount,
+			Remarks:               reason,
+			CorrespondingUserID:   original.CorrespondingUserID,
+			ReferenceID:           &referenceId,
+			OriginalTransactionID: &original.ID,
+		}
+		if err := bookTransaction(tx, counterAccountCode(original.Category), refund, "refund"); err != nil {
+			return err
+		}
+		if err := refundFee(tx, &original, amount, &referenceId, reason); err != nil {
+			return err
+		}
+
+		if original.RefundedAmount < original.Amount {
+			return nil
+		}
+
+		if err := transitionTransactionStatus(tx, &original, TransactionStatusReversed, "fully refunded"); err != nil {
+			return err
+		}
+		if recipientLeg != nil {
+			return transitionTransactionStatus(tx, recipientLeg, TransactionStatusReversed, "fully refunded")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return refund, &original, nil
+}
+
+// findTransferCreditLeg returns the recipient's side of a transfer. Only
+// transfers that carry a reference can be matched up.
+func findTransferCreditLeg(tx *gorm.DB, debit *Transaction) (*Transaction, error) {
+	if debit.ReferenceID == nil {
+		return nil, ErrTransactionNotRefundable
+	}
+
+	var credit Transaction
+	err := tx.Where("reference_id = ? AND type = ?", *debit.ReferenceID, "CREDIT").First(&credit).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrTransactionNotRefundable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &credit, nil
+}
+
+// bookTransaction stores a transaction that takes effect right away: it is
+// posted between the user's wallet and the counter account and settled as
+// SUCCESS. A DEBIT fails with ErrInsufficientBalance when the balance does
+// not cover it.
+func bookTransaction(tx *gorm.DB, counterAccountCode string, transaction *Transaction, reason string) error {
+	var user User
+	if err := tx.First(&user, &User{ID: transaction.UserID}).Error; err != nil {
+		return err
+	}
+
+	balanceAfter := user.Balance + transaction.Amount
+	if transaction.Type == "DEBIT" {
+		if user.Balance < transaction.Amount {
+			return ErrInsufficientBalance
+		}
+		balanceAfter = user.Balance - transaction.Amount
+	}
+
+	transaction.ID = uuid.New()
+	transaction.Status = TransactionStatusPending
+	transaction.BalanceBefore = user.Balance
+	transaction.BalanceAfter = balanceAfter
+	transaction.CreatedAt = time.Now()
+	if err := tx.Create(transaction).Error; err != nil {
+		return err
+	}
+	if err := recordTransactionStatus(tx, transaction, "", "created"); err != nil {
+		return err
+	}
+
+	if err := postWalletJournal(tx, transaction, counterAccountCode); err != nil {
+		return err
+	}
+
+	return transitionTransactionStatus(tx, transaction, TransactionStatusSuccess, reason)
+}
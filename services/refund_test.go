@@ -0,0 +1,143 @@
+package services
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestRefundTransactionPartialRefundsAddUp(t *testing.T) {
+	openTestDB(t)
+	useFeeRules(t, nil)
+	user, payment := createRefundTestPayment(t, 1000)
+
+	for _, amount := range []int64{400, 350, 250} {
+		if _, _, err := RefundTransaction(payment.ID, amount, "refund"); err != nil {
+			t.Fatalf("refund of %d: %v", amount, err)
+		}
+	}
+
+	if _, _, err := RefundTransaction(payment.ID, 1, "refund"); err != ErrTransactionNotRefundable {
+		t.Errorf("refunding a fully refunded payment returned %v, want %v", err, ErrTransactionNotRefundable)
+	}
+
+	original := loadTransaction(t, payment.ID)
+	if original.RefundedAmount != 1000 || original.Status != TransactionStatusReversed {
+		t.Errorf("payment has %d refunded with status %s, want 1000 and %s", original.RefundedAmount, original.Status, TransactionStatusReversed)
+	}
+	assertBalance(t, user.ID, 100000)
+	assertLedgerBalanced(t)
+}
+
+func TestRefundTransactionAboveTheAmount(t *testing.T) {
+	openTestDB(t)
+	useFeeRules(t, nil)
+	user, payment := createRefundTestPayment(t, 1000)
+
+	if _, _, err := RefundTransaction(payment.ID, 1001, "refund"); err != ErrRefundExceedsAmount {
+		t.Errorf("refunding more than the amount returned %v, want %v", err, ErrRefundExceedsAmount)
+	}
+
+	if _, _, err := RefundTransaction(payment.ID, 600, "refund"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RefundTransaction(payment.ID, 401, "refund"); err != ErrRefundExceedsAmount {
+		t.Errorf("refunding more than is left returned %v, want %v", err, ErrRefundExceedsAmount)
+	}
+
+	original := loadTransaction(t, payment.ID)
+	if original.RefundedAmount != 600 || original.Status != TransactionStatusSuccess {
+		t.Errorf("payment has %d refunded with status %s, want 600 and %s", original.RefundedAmount, original.Status, TransactionStatusSuccess)
+	}
+	assertBalance(t, user.ID, 99600)
+	assertLedgerBalanced(t)
+}
+
+func TestRefundTransactionConcurrently(t *testing.T) {
+	openTestDB(t)
+	useFeeRules(t, nil)
+	user, payment := createRefundTestPayment(t, 1000)
+
+	const refunds = 10
+	start := make(chan struct{})
+	errs := make(chan error, refunds)
+	var wg sync.WaitGroup
+	for i := 0; i < refunds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, _, err := RefundTransaction(payment.ID, 300, "refund")
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrRefundExceedsAmount:
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 3 {
+		t.Errorf("%d refunds succeeded, want 3", succeeded)
+	}
+
+	original := loadTransaction(t, payment.ID)
+	if original.RefundedAmount != 900 {
+		t.Errorf("payment has %d refunded, want 900", original.RefundedAmount)
+	}
+	assertBalance(t, user.ID, 99900)
+	assertLedgerBalanced(t)
+}
+
+// createRefundTestPayment funds a wallet with 100000 and pays the amount
+// from it.
+func createRefundTestPayment(t *testing.T, amount int64) (*User, *Transaction) {
+	t.Helper()
+
+	user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateCreditTransaction(user.ID, NewTransactionRequest{UserID: user.ID, Amount: 100000, Category: CategoryTopUp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := CreateDebitTransaction(user.ID, NewTransactionRequest{UserID: user.ID, Amount: amount, Category: CategoryPayment})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, payment
+}
+
+func loadTransaction(t *testing.T, id uuid.UUID) *Transaction {
+	t.Helper()
+
+	var transaction Transaction
+	if err := database.DB.First(&transaction, &Transaction{ID: id}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &transaction
+}
+
+func assertBalance(t *testing.T, userId uuid.UUID, want int64) {
+	t.Helper()
+
+	user, err := GetUserByID(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Balance != want {
+		t.Errorf("balance is %d, want %d", user.Balance, want)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/clause"
+)
+
+// TokenRevocationStore keeps the ids (jti) of access tokens that must be
+// rejected before they expire, plus per-user cut-offs that reject every
+// token issued to a user before a given time. Claim revokes a jti only if it
+// was not revoked yet, so a single-use token can be spent exactly once, and
+// Release undoes a claim whose use did not go through.
+type TokenRevocationStore interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	Claim(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	Release(ctx context.Context, jti string) error
+	RevokeUser(ctx context.Context, userId string, issuedBefore time.Time) error
+	IsRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error)
+}
+
+// TokenRevocations is the store consulted by middleware.Auth. It defaults to
+// the SQL database and is swapped for Redis in main when REDIS_URL is set.
+var TokenRevocations TokenRevocationStore = &DBTokenRevocationStore{}
+
+type RevokedToken entity.RevokedToken
+
+type DBTokenRevocationStore struct{}
+
+func (s *DBTokenRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	db := database.DB.WithContext(ctx)
+	revokedToken := &RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(revokedToken).Error
+	if err != nil {
+		return err
+	}
+
+	return s.purgeExpired(ctx)
+}
+
+func (s *DBTokenRevocationStore) Claim(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	db := database.DB.WithContext(ctx)
+	revokedToken := &RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	// the primary key on jti lets only one claim insert the row
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(revokedToken)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, s.purgeExpired(ctx)
+}
+
+func (s *DBTokenRevocationStore) Release(ctx context.Context, jti string) error {
+	db := database.DB.WithContext(ctx)
+	return db.Where("jti = ?", jti).Delete(&RevokedToken{}).Error
+}
+
+func (s *DBTokenRevocationStore) RevokeUser(ctx context.Context, userId string, issuedBefore time.Time) error {
+	db := database.DB.WithContext(ctx)
+	revokedToken := &RevokedToken{
+		JTI:          revokedUserKey(userId),
+		IssuedBefore: &issuedBefore,
+		ExpiresAt:    issuedBefore.Add(AccessTokenTTL),
+		CreatedAt:    time.Now(),
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "jti"}},
+		DoUpdates: clause.AssignmentColumns([]string{"issued_before", "expires_at"}),
+	}).Create(revokedToken).Error
+	if err != nil {
+		return err
+	}
+
+	return s.purgeExpired(ctx)
+}
+
+func (s *DBTokenRevocationStore) IsRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error) {
+	db := database.DB.WithContext(ctx)
+	var count int64
+	err := db.Model(&RevokedToken{}).
+		Where("jti = ?", jti).
+		Or("jti = ? AND issued_before > ?", revokedUserKey(userId), issuedAt).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// purgeExpired drops entries that can never match a valid token again.
+func (s *DBTokenRevocationStore) purgeExpired(ctx context.Context) error {
+	db := database.DB.WithContext(ctx)
+	return db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+}
+
+type RedisTokenRevocationStore struct {
+	client *redis.Client
+}
+
+func NewRedisTokenRevocationStore(client *redis.Client) *RedisTokenRevocationStore {
+	return &RedisTokenRevocationStore{client: client}
+}
+
+func (s *RedisTokenRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return s.client.Set(ctx, revokedTokenKey(jti), 1, ttl).Err()
+}
+
+func (s *RedisTokenRevocationStore) Claim(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+
+	return s.client.SetNX(ctx, revokedTokenKey(jti), 1, ttl).Result()
+}
+
+func (s *RedisTokenRevocationStore) Release(ctx context.Context, jti string) error {
+	return s.client.Del(ctx, revokedTokenKey(jti)).Err()
+}
+
+func (s *RedisTokenRevocationStore) RevokeUser(ctx context.Context, userId string, issuedBefore time.Time) error {
+	return s.client.Set(ctx, revokedUserKey(userId), issuedBefore.UnixNano(), AccessTokenTTL).Err()
+}
+
+func (s *RedisTokenRevocationStore) IsRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error) {
+	count, err := s.client.Exists(ctx, revokedTokenKey(jti)).Result()
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	value, err := s.client.Get(ctx, revokedUserKey(userId)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	issuedBefore, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Before(time.Unix(0, issuedBefore)), nil
+}
+
+func revokedTokenKey(jti string) string {
+	return "revoked_token:" + jti
+}
+
+func revokedUserKey(userId string) string {
+	return "revoked_user:" + userId
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
+	"gorm.io/gorm"
+)
+
+const (
+	ScheduleFrequencyOnce    = "ONCE"
+	ScheduleFrequencyDaily   = "DAILY"
+	ScheduleFrequencyWeekly  = "WEEKLY"
+	ScheduleFrequencyMonthly = "MONTHLY"
+)
+
+const (
+	ScheduleStatusActive    = "ACTIVE"
+	ScheduleStatusPaused    = "PAUSED"
+	ScheduleStatusCancelled = "CANCELLED"
+	ScheduleStatusCompleted = "COMPLETED"
+	ScheduleStatusFailed    = "FAILED"
+)
+
+// how long a claimed schedule is hidden from other scheduler runs
+const scheduledTransferLease = time.Minute * 5
+
+var (
+	ErrScheduledTransferNotFound = errors.New("scheduled transfer not found")
+	ErrInvalidScheduleFrequency  = errors.New("frequency is invalid")
+	ErrScheduleStartInPast       = errors.New("start time must be in the future")
+	ErrScheduleEndBeforeStart    = errors.New("end time must not be before the start time")
+	ErrScheduleStatusConflict    = errors.New("scheduled transfer cannot be changed in its current status")
+
+	errScheduledTransferLeaseLost = errors.New("scheduled transfer was claimed by another run")
+)
+
+type ScheduledTransfer entity.ScheduledTransfer
+
+type NewScheduledTransferRequest struct {
+	RecipientID uuid.UUID  `json:"recipient_id" validate:"required"`
+	Amount      int64      `json:"amount" validate:"required,gt=0"`
+	Remarks     string     `json:"remarks" validate:"max=255"`
+	Frequency   string     `json:"frequency"`
+	StartAt     time.Time  `json:"start_at"`
+	EndAt       *time.Time `json:"end_at"`
+}
+
+func CreateScheduledTransfer(userId uuid.UUID, request NewScheduledTransferRequest) (*ScheduledTransfer, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+
+	switch request.Frequency {
+	case ScheduleFrequencyOnce, ScheduleFrequencyDaily, ScheduleFrequencyWeekly, ScheduleFrequencyMonthly:
+	default:
+		return nil, ErrInvalidScheduleFrequency
+	}
+	if !request.StartAt.After(time.Now()) {
+		return nil, ErrScheduleStartInPast
+	}
+	if request.EndAt != nil && request.EndAt.Before(request.StartAt) {
+		return nil, ErrScheduleEndBeforeStart
+	}
+
+	db := database.DB
+	recipient, err := checkRecipient(db, userId, request.RecipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &ScheduledTransfer{
+		ID:           uuid.New(),
+		UserID:       userId,
+		RecipientID:  recipient.ID,
+		Amount:       request.Amount,
+		Remarks:      request.Remarks,
+		Frequency:    request.Frequency,
+		StartAt:      request.StartAt,
+		EndAt:        request.EndAt,
+		Status:       ScheduleStatusActive,
+		OccurrenceAt: &request.StartAt,
+		NextRunAt:    &request.StartAt,
+		CreatedAt:    time.Now(),
+		Recipient:    entity.User(*recipient),
+	}
+	if err := db.Omit("Recipient").Create(schedule).Error; err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func GetScheduledTransfers(userId uuid.UUID) ([]ScheduledTransfer, error) {
+	db := database.DB
+	schedules := []ScheduledTransfer{}
+	err := db.Preload("Recipient").
+		Where("user_id = ?", userId).
+		Order("created_at desc").
+		Find(&schedules).Error
+	return schedules, err
+}
+
+func PauseScheduledTransfer(userId uuid.UUID, scheduleId uuid.UUID) (*ScheduledTransfer, error) {
+	return changeScheduledTransferStatus(userId, scheduleId, ScheduleStatusPaused)
+}
+
+// ResumeScheduledTransfer reactivates a paused schedule. Occurrences missed
+// while it was paused are skipped, except for a one-off transfer, which is
+// made right away.
+func ResumeScheduledTransfer(userId uuid.UUID, scheduleId uuid.UUID) (*ScheduledTransfer, error) {
+	return changeScheduledTransferStatus(userId, scheduleId, ScheduleStatusActive)
+}
+
+func CancelScheduledTransfer(userId uuid.UUID, scheduleId uuid.UUID) (*ScheduledTransfer, error) {
+	return changeScheduledTransferStatus(userId, scheduleId, ScheduleStatusCancelled)
+}
+
+func changeScheduledTransferStatus(userId uuid.UUID, scheduleId uuid.UUID, status string) (*ScheduledTransfer, error) {
+	db := database.DB
+	var schedule ScheduledTransfer
+	err := db.Preload("Recipient").
+		First(&schedule, &ScheduledTransfer{ID: scheduleId, UserID: userId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrScheduledTransferNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fromStatus := schedule.Status
+	switch status {
+	case ScheduleStatusPaused:
+		if fromStatus != ScheduleStatusActive {
+			return nil, ErrScheduleStatusConflict
+		}
+	case ScheduleStatusActive:
+		if fromStatus != ScheduleStatusPaused {
+			return nil, ErrScheduleStatusConflict
+		}
+		now := time.Now()
+		if schedule.OccurrenceAt == nil {
+			// the last occurrence was made while it was paused
+			status = ScheduleStatusCompleted
+		} else if schedule.OccurrenceAt.Before(now) {
+			if schedule.Frequency == ScheduleFrequencyOnce {
+				schedule.NextRunAt = &now
+			} else if advanceScheduledTransfer(&schedule, now) {
+				status = ScheduleStatusCompleted
+			}
+		} else {
+			schedule.NextRunAt = schedule.OccurrenceAt
+		}
+	case ScheduleStatusCancelled:
+		if fromStatus != ScheduleStatusActive && fromStatus != ScheduleStatusPaused {
+			return nil, ErrScheduleStatusConflict
+		}
+		schedule.NextRunAt = nil
+	}
+
+	schedule.Status = status
+	result := db.Model(&ScheduledTransfer{}).
+		Where("id = ? AND status = ?", schedule.ID, fromStatus).
+		Updates(map[string]interface{}{
+			"status":        schedule.Status,
+			"occurrences":   schedule.Occurrences,
+			"occurrence_at": schedule.OccurrenceAt,
+			"next_run_at":   schedule.NextRunAt,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrScheduleStatusConflict
+	}
+
+	return &schedule, nil
+}
+
+// RunTransferScheduler executes due scheduled transfers every
+// SCHEDULER_INTERVAL until ctx is cancelled.
+func RunTransferScheduler(ctx context.Context) {
+	ticker := time.NewTicker(getenvDuration("SCHEDULER_INTERVAL", time.Second*30))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RunDueScheduledTransfers(ctx); err != nil {
+				log.Printf("transfer scheduler: %v", err)
+			}
+		}
+	}
+}
+
+func RunDueScheduledTransfers(ctx context.Context) error {
+	db := database.DB
+	scheduleIds := []uuid.UUID{}
+	err := db.Model(&ScheduledTransfer{}).
+		Where("status = ? AND next_run_at <= ?", ScheduleStatusActive, time.Now()).
+		Order("next_run_at asc").
+		Pluck("id", &scheduleIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, scheduleId := range scheduleIds {
+		if err := runScheduledTransfer(ctx, scheduleId); err != nil {
+			log.Printf("scheduled transfer %s: %v", scheduleId, err)
+		}
+	}
+
+	return nil
+}
+
+// runScheduledTransfer makes the transfer that is due through the same path
+// as a synchronous transfer. A failed attempt, e.g. for a low balance, is
+// retried every SCHEDULED_TRANSFER_RETRY_INTERVAL and the occurrence is
+// skipped after SCHEDULED_TRANSFER_MAX_ATTEMPTS; the sender is notified by
+// SMS of the first failure and when giving up.
+func runScheduledTransfer(ctx context.Context, scheduleId uuid.UUID) error {
+	db := database.DB
+	now := time.Now()
+
+	// claim the schedule so an overlapping run does not pay it twice
+	leaseUntil := now.Add(scheduledTransferLease)
+	result := db.Model(&ScheduledTransfer{}).
+		Where("id = ? AND status = ? AND next_run_at <= ?", scheduleId, ScheduleStatusActive, now).
+		Update("next_run_at", leaseUntil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	var schedule ScheduledTransfer
+	if err := db.Preload("Recipient").First(&schedule, &ScheduledTransfer{ID: scheduleId}).Error; err != nil {
+		return err
+	}
+	schedule.LastRunAt = &now
+
+	// the transfer commits together with the schedule moving on, so a crash
+	// in between can't leave the occurrence paid but still due
+	transferErr := db.Transaction(func(tx *gorm.DB) error {
+		if err := makeScheduledTransfer(tx, &schedule); err != nil {
+			return err
+		}
+
+		paid := schedule
+		paid.Attempts = 0
+		paid.LastError = ""
+		paid.Occurrences++
+		finished := advanceScheduledTransfer(&paid, now)
+		return saveScheduledRun(tx, &paid, leaseUntil, finished, nil)
+	})
+	if transferErr == nil || transferErr == errScheduledTransferLeaseLost {
+		return nil
+	}
+
+	finished := false
+	notification := ""
+	maxAttempts := getenvInt("SCHEDULED_TRANSFER_MAX_ATTEMPTS", 3)
+	schedule.Attempts++
+	schedule.LastError = transferErr.Error()
+
+	if schedule.Attempts < maxAttempts {
+		nextRunAt := now.Add(getenvDuration("SCHEDULED_TRANSFER_RETRY_INTERVAL", time.Hour))
+		schedule.NextRunAt = &nextRunAt
+		if schedule.Attempts == 1 {
+			notification = fmt.Sprintf("Your scheduled transfer of %d to %s could not be made: %s. We will try again at %s.",
+				schedule.Amount, recipientName(&schedule), transferErr, nextRunAt.Format("2006-01-02 15:04"))
+		}
+	} else {
+		notification = fmt.Sprintf("Your scheduled transfer of %d to %s was skipped after %d attempts: %s.",
+			schedule.Amount, recipientName(&schedule), schedule.Attempts, transferErr)
+		schedule.Attempts = 0
+		schedule.Occurrences++
+		finished = advanceScheduledTransfer(&schedule, now)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return saveScheduledRun(tx, &schedule, leaseUntil, finished, transferErr)
+	})
+	if err == errScheduledTransferLeaseLost {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if notification != "" {
+		var sender User
+		if err := db.First(&sender, &User{ID: schedule.UserID}).Error; err != nil {
+			return err
+		}
+		if err := SMS.Send(ctx, sender.PhoneNumber, notification); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveScheduledRun stores the outcome of a run. It fails with
+// errScheduledTransferLeaseLost when next_run_at no longer holds this run's
+// lease, i.e. the lease ran out and another run claimed the schedule or it
+// was resumed meanwhile; the caller's transaction must then roll back.
+func saveScheduledRun(tx *gorm.DB, schedule *ScheduledTransfer, leaseUntil time.Time, finished bool, transferErr error) error {
+	// the status is left alone here so a pause or cancel made during the
+	// run is kept
+	result := tx.Model(&ScheduledTransfer{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, leaseUntil).
+		Updates(map[string]interface{}{
+			"occurrences":   schedule.Occurrences,
+			"occurrence_at": schedule.OccurrenceAt,
+			"next_run_at":   schedule.NextRunAt,
+			"attempts":      schedule.Attempts,
+			"last_error":    schedule.LastError,
+			"last_run_at":   schedule.LastRunAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errScheduledTransferLeaseLost
+	}
+
+	if !finished {
+		return nil
+	}
+
+	status := ScheduleStatusCompleted
+	if transferErr != nil && schedule.Frequency == ScheduleFrequencyOnce {
+		status = ScheduleStatusFailed
+	}
+	return tx.Model(&ScheduledTransfer{}).
+		Where("id = ? AND status = ?", schedule.ID, ScheduleStatusActive).
+		Update("status", status).Error
+}
+
+func makeScheduledTransfer(tx *gorm.DB, schedule *ScheduledTransfer) error {
+	legs := []NewTransactionRequest{
+		{
+			UserID:              schedule.UserID,
+			Amount:              schedule.Amount,
+			Remarks:             schedule.Remarks,
+			Category:            CategoryTransfer,
+			Channel:             ChannelApp,
+			Type:                sql.NullString{String: "DEBIT", Valid: true},
+			CorrespondingUserID: schedule.RecipientID,
+		},
+		{
+			UserID:              schedule.RecipientID,
+			Amount:              schedule.Amount,
+			Remarks:             schedule.Remarks,
+			Category:            CategoryTransfer,
+			Channel:             ChannelApp,
+			Type:                sql.NullString{String: "CREDIT", Valid: true},
+			CorrespondingUserID: schedule.UserID,
+		},
+	}
+
+	_, err := CreateMultipleTransactionsWithDbTransaction(legs, tx)
+	return err
+}
+
+// advanceScheduledTransfer moves the schedule to its first occurrence after
+// now, skipping any that were missed. It reports true when there is none
+// left.
+func advanceScheduledTransfer(schedule *ScheduledTransfer, now time.Time) bool {
+	if schedule.Frequency != ScheduleFrequencyOnce {
+		for {
+			next := scheduledOccurrence(schedule.StartAt, schedule.Frequency, schedule.Occurrences)
+			if schedule.EndAt != nil && next.After(*schedule.EndAt) {
+				break
+			}
+			if next.After(now) {
+				schedule.OccurrenceAt = &next
+				schedule.NextRunAt = &next
+				return false
+			}
+			schedule.Occurrences++
+		}
+	}
+
+	schedule.OccurrenceAt = nil
+	schedule.NextRunAt = nil
+	return true
+}
+
+// scheduledOccurrence returns the time of the n-th occurrence (from 0). A
+// monthly schedule that starts on a day some months lack, e.g. the 31st,
+// runs on the last day of those months.
+func scheduledOccurrence(startAt time.Time, frequency string, n int) time.Time {
+	switch frequency {
+	case ScheduleFrequencyDaily:
+		return startAt.AddDate(0, 0, n)
+	case ScheduleFrequencyWeekly:
+		return startAt.AddDate(0, 0, 7*n)
+	case ScheduleFrequencyMonthly:
+		year, month := startAt.Year(), startAt.Month()+time.Month(n)
+		lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, startAt.Location()).Day()
+		day := startAt.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return time.Date(year, month, day, startAt.Hour(), startAt.Minute(), startAt.Second(), startAt.Nanosecond(), startAt.Location())
+	}
+	return startAt
+}
+
+func recipientName(schedule *ScheduledTransfer) string {
+	return strings.TrimSpace(schedule.Recipient.FirstName + " " + schedule.Recipient.LastName)
+}
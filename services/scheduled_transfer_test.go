@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+func TestScheduledOccurrence(t *testing.T) {
+	jan31 := time.Date(2024, time.January, 31, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		startAt   time.Time
+		frequency string
+		n         int
+		want      time.Time
+	}{
+		{"first occurrence is the start", jan31, ScheduleFrequencyMonthly, 0, jan31},
+		{"clamped to the end of a leap February", jan31, ScheduleFrequencyMonthly, 1, time.Date(2024, time.February, 29, 9, 30, 0, 0, time.UTC)},
+		{"back to the 31st after a short month", jan31, ScheduleFrequencyMonthly, 2, time.Date(2024, time.March, 31, 9, 30, 0, 0, time.UTC)},
+		{"clamped to the 30th", jan31, ScheduleFrequencyMonthly, 3, time.Date(2024, time.April, 30, 9, 30, 0, 0, time.UTC)},
+		{"clamped to the end of a common February", jan31, ScheduleFrequencyMonthly, 13, time.Date(2025, time.February, 28, 9, 30, 0, 0, time.UTC)},
+		{"across the year end", jan31, ScheduleFrequencyMonthly, 11, time.Date(2024, time.December, 31, 9, 30, 0, 0, time.UTC)},
+		{"daily", jan31, ScheduleFrequencyDaily, 1, time.Date(2024, time.February, 1, 9, 30, 0, 0, time.UTC)},
+		{"weekly", jan31, ScheduleFrequencyWeekly, 2, time.Date(2024, time.February, 14, 9, 30, 0, 0, time.UTC)},
+		{"once never moves", jan31, ScheduleFrequencyOnce, 5, jan31},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := scheduledOccurrence(test.startAt, test.frequency, test.n)
+			if !got.Equal(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAdvanceScheduledTransfer(t *testing.T) {
+	jan31 := time.Date(2024, time.January, 31, 9, 30, 0, 0, time.UTC)
+	mar31 := time.Date(2024, time.March, 31, 9, 30, 0, 0, time.UTC)
+
+	t.Run("moves to the next occurrence", func(t *testing.T) {
+		schedule := &ScheduledTransfer{StartAt: jan31, Frequency: ScheduleFrequencyMonthly, Occurrences: 1}
+		now := jan31.Add(time.Minute)
+
+		if advanceScheduledTransfer(schedule, now) {
+			t.Fatal("reported finished")
+		}
+		want := time.Date(2024, time.February, 29, 9, 30, 0, 0, time.UTC)
+		if !schedule.NextRunAt.Equal(want) || !schedule.OccurrenceAt.Equal(want) {
+			t.Errorf("next run at %v, want %v", schedule.NextRunAt, want)
+		}
+		if schedule.Occurrences != 1 {
+			t.Errorf("occurrences is %d, want 1", schedule.Occurrences)
+		}
+	})
+
+	t.Run("skips occurrences missed while paused", func(t *testing.T) {
+		schedule := &ScheduledTransfer{StartAt: jan31, Frequency: ScheduleFrequencyMonthly, Occurrences: 1}
+		now := time.Date(2024, time.April, 15, 0, 0, 0, 0, time.UTC)
+
+		if advanceScheduledTransfer(schedule, now) {
+			t.Fatal("reported finished")
+		}
+		want := time.Date(2024, time.April, 30, 9, 30, 0, 0, time.UTC)
+		if !schedule.NextRunAt.Equal(want) {
+			t.Errorf("next run at %v, want %v", schedule.NextRunAt, want)
+		}
+		if schedule.Occurrences != 3 {
+			t.Errorf("occurrences is %d, want 3", schedule.Occurrences)
+		}
+	})
+
+	t.Run("an occurrence at the end time still runs", func(t *testing.T) {
+		schedule := &ScheduledTransfer{StartAt: jan31, EndAt: &mar31, Frequency: ScheduleFrequencyMonthly, Occurrences: 2}
+
+		if advanceScheduledTransfer(schedule, mar31.Add(-time.Hour)) {
+			t.Fatal("reported finished")
+		}
+		if !schedule.NextRunAt.Equal(mar31) {
+			t.Errorf("next run at %v, want %v", schedule.NextRunAt, mar31)
+		}
+	})
+
+	t.Run("finishes after the end time", func(t *testing.T) {
+		schedule := &ScheduledTransfer{StartAt: jan31, EndAt: &mar31, Frequency: ScheduleFrequencyMonthly, Occurrences: 3}
+
+		if !advanceScheduledTransfer(schedule, mar31) {
+			t.Fatal("did not report finished")
+		}
+		if schedule.NextRunAt != nil || schedule.OccurrenceAt != nil {
+			t.Errorf("next run at %v, want none", schedule.NextRunAt)
+		}
+	})
+
+	t.Run("a one-off transfer finishes", func(t *testing.T) {
+		schedule := &ScheduledTransfer{StartAt: jan31, Frequency: ScheduleFrequencyOnce, Occurrences: 1}
+
+		if !advanceScheduledTransfer(schedule, jan31) {
+			t.Fatal("did not report finished")
+		}
+	})
+}
+
+func TestResumeScheduledTransferSkipsMissedOccurrences(t *testing.T) {
+	openTestDB(t)
+	sender, recipient := createScheduleTestUsers(t)
+
+	now := time.Now()
+	startAt := now.AddDate(0, 0, -3).Add(time.Hour)
+	schedule := createTestSchedule(t, sender.ID, recipient.ID, ScheduleFrequencyDaily, startAt, ScheduleStatusPaused)
+
+	resumed, err := ResumeScheduledTransfer(sender.ID, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// today's occurrence is an hour away, the three before it were missed
+	want := startAt.AddDate(0, 0, 3)
+	if resumed.Status != ScheduleStatusActive {
+		t.Errorf("status is %s, want %s", resumed.Status, ScheduleStatusActive)
+	}
+	if resumed.NextRunAt == nil || !resumed.NextRunAt.Equal(want) {
+		t.Errorf("next run at %v, want %v", resumed.NextRunAt, want)
+	}
+	if resumed.Occurrences != 3 {
+		t.Errorf("occurrences is %d, want 3", resumed.Occurrences)
+	}
+}
+
+func TestRunScheduledTransferPaysOnceAndAdvances(t *testing.T) {
+	openTestDB(t)
+	sender, recipient := createScheduleTestUsers(t)
+
+	startAt := time.Now().Add(-time.Hour)
+	schedule := createTestSchedule(t, sender.ID, recipient.ID, ScheduleFrequencyDaily, startAt, ScheduleStatusActive)
+
+	for i := 0; i < 2; i++ {
+		if err := RunDueScheduledTransfers(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var paid int64
+	err := database.DB.Model(&Transaction{}).
+		Where("user_id = ? AND category = ? AND type = ?", sender.ID, CategoryTransfer, "DEBIT").
+		Count(&paid).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid != 1 {
+		t.Errorf("%d transfers made, want 1", paid)
+	}
+
+	var saved ScheduledTransfer
+	if err := database.DB.First(&saved, &ScheduledTransfer{ID: schedule.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := startAt.AddDate(0, 0, 1)
+	if saved.Occurrences != 1 {
+		t.Errorf("occurrences is %d, want 1", saved.Occurrences)
+	}
+	if saved.NextRunAt == nil || !saved.NextRunAt.Equal(want) {
+		t.Errorf("next run at %v, want %v", saved.NextRunAt, want)
+	}
+}
+
+func createScheduleTestUsers(t *testing.T) (*User, *User) {
+	t.Helper()
+
+	sender, err := CreateUser(&User{FirstName: "Sender", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := CreateUser(&User{FirstName: "Recipient", PhoneNumber: "081200000002", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateCreditTransaction(sender.ID, NewTransactionRequest{
+		UserID:   sender.ID,
+		Amount:   100000,
+		Category: CategoryTopUp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sender, recipient
+}
+
+// createTestSchedule stores a schedule directly, as CreateScheduledTransfer
+// refuses start times in the past.
+func createTestSchedule(t *testing.T, userId uuid.UUID, recipientId uuid.UUID, frequency string, startAt time.Time, status string) *ScheduledTransfer {
+	t.Helper()
+
+	schedule := &ScheduledTransfer{
+		ID:           uuid.New(),
+		UserID:       userId,
+		RecipientID:  recipientId,
+		Amount:       10000,
+		Frequency:    frequency,
+		StartAt:      startAt,
+		Status:       status,
+		OccurrenceAt: &startAt,
+		NextRunAt:    &startAt,
+		CreatedAt:    time.Now(),
+	}
+	if err := database.DB.Omit("Recipient").Create(schedule).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return schedule
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+// how often the last seen time of a session is written back
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+)
+
+type Session entity.Session
+
+func CreateSession(userId uuid.UUID, deviceName string, userAgent string, ipAddress string) (*Session, error) {
+	db := database.DB
+	session := &Session{
+		ID:         uuid.New(),
+		UserID:     userId,
+		DeviceName: deviceName,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: time.Now(),
+		CreatedAt:  time.Now(),
+	}
+
+	if err := db.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func GetActiveSessions(userId uuid.UUID) ([]Session, error) {
+	db := database.DB
+	sessions := []Session{}
+	err := db.Where("user_id = ? AND revoked_at IS NULL", userId).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// ValidateSession checks that the session belongs to the user and has not
+// been revoked, and records the request as its latest activity.
+func ValidateSession(sessionId uuid.UUID, userId uuid.UUID, ipAddress string) error {
+	db := database.DB
+	var session Session
+	err := db.First(&session, &Session{ID: sessionId, UserID: userId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+
+	if time.Since(session.LastSeenAt) < sessionTouchInterval && session.IPAddress == ipAddress {
+		return nil
+	}
+
+	return TouchSession(sessionId, ipAddress)
+}
+
+func TouchSession(sessionId uuid.UUID, ipAddress string) error {
+	db := database.DB
+	return db.Model(&Session{}).
+		Where("id = ?", sessionId).
+		Updates(map[string]interface{}{
+			"last_seen_at": time.Now(),
+			"ip_address":   ipAddress,
+		}).Error
+}
+
+// RevokeSession logs a device out: the session is marked revoked, which makes
+// middleware.Auth reject its access tokens, and its refresh tokens are revoked.
+func RevokeSession(userId uuid.UUID, sessionId uuid.UUID) error {
+	db := database.DB
+	result := db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionId, userId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return RevokeRefreshTokenFamily(sessionId)
+}
+
+// RevokeAllUserSessions revokes every session and refresh token of the user
+// and rejects all access tokens issued to them so far.
+func RevokeAllUserSessions(ctx context.Context, userId uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	// iat only has second precision, so round the cut-off up: a token issued
+	// earlier in this second must not survive. Tokens issued later in the same
+	// second are revoked too, which only costs the user another login.
+	return TokenRevocations.RevokeUser(ctx, userId.String(), time.Now().Truncate(time.Second).Add(time.Second))
+}
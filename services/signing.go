@@ -0,0 +1,240 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const tokenIssuer = "technical-test-fm-tahap-2"
+
+var (
+	ErrUnknownSigningKey        = errors.New("token signed with an unknown key")
+	ErrSigningKeysNotConfigured = errors.New("JWT_KEYS_DIR is not set; set APP_ENV=development to sign with an ephemeral key")
+)
+
+// SigningKey is one key of the key set. Verify-only keys have no Private
+// part; they are kept around so tokens signed before a rotation stay valid.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// SigningKeys holds the keys used to sign and verify our JWTs. It is filled
+// by LoadSigningKeys on startup.
+var SigningKeys *KeySet
+
+// LoadSigningKeys reads every *.pem file in JWT_KEYS_DIR, using the file name
+// as the key id. RSA keys sign with RS256 and Ed25519 keys with EdDSA. Tokens
+// are signed with JWT_ACTIVE_KID, or with the only private key when there is
+// just one. Without JWT_KEYS_DIR startup fails, unless APP_ENV is
+// "development": then an ephemeral Ed25519 key is generated, which ends every
+// session on restart and isn't shared between instances.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("APP_ENV") != "development" {
+			return ErrSigningKeysNotConfigured
+		}
+		log.Println("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key")
+		keySet, err := newEphemeralKeySet()
+		if err != nil {
+			return err
+		}
+		SigningKeys = keySet
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	keySet := &KeySet{keys: map[string]*SigningKey{}}
+	privateKeys := []*SigningKey{}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := readSigningKey(kid, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		keySet.keys[kid] = key
+		if key.Private != nil {
+			privateKeys = append(privateKeys, key)
+		}
+	}
+
+	activeKid := os.Getenv("JWT_ACTIVE_KID")
+	if activeKid == "" && len(privateKeys) == 1 {
+		activeKid = privateKeys[0].ID
+	}
+
+	active, ok := keySet.keys[activeKid]
+	if !ok || active.Private == nil {
+		return fmt.Errorf("no private key found for active kid %q in %s", activeKid, dir)
+	}
+	keySet.active = active
+
+	SigningKeys = keySet
+	return nil
+}
+
+// Sign signs the claims with the active key and sets its kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.Private)
+}
+
+// Parse verifies a token against the key named by its kid header and
+// validates the standard claims.
+func (k *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, ErrUnknownSigningKey
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("signing method invalid")
+		}
+
+		return key.Public, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("token is invalid")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public part of every key as a JSON Web Key Set.
+func (k *KeySet) JWKS() map[string]interface{} {
+	kids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := []map[string]string{}
+	for _, kid := range kids {
+		key := k.keys[kid]
+		jwk := map[string]string{
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
+
+func readSigningKey(kid string, path string) (*SigningKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(kid, parsed)
+}
+
+func newSigningKey(kid string, parsed interface{}) (*SigningKey, error) {
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Public: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: key, Public: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+func newEphemeralKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSigningKey("ephemeral-"+uuid.NewString(), private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySet{
+		active: key,
+		keys:   map[string]*SigningKey{key.ID: key},
+	}, nil
+}
@@ -0,0 +1,22 @@
+package services
+
+import "testing"
+
+func TestLoadSigningKeysWithoutKeysDir(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+	previous := SigningKeys
+	t.Cleanup(func() { SigningKeys = previous })
+
+	t.Setenv("APP_ENV", "")
+	if err := LoadSigningKeys(); err != ErrSigningKeysNotConfigured {
+		t.Errorf("outside development got %v, want %v", err, ErrSigningKeysNotConfigured)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	if err := LoadSigningKeys(); err != nil {
+		t.Fatalf("in development got %v", err)
+	}
+	if SigningKeys == nil || SigningKeys.active == nil {
+		t.Error("no ephemeral key was generated")
+	}
+}
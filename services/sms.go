@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SMSSender delivers text messages to a phone number.
+type SMSSender interface {
+	Send(ctx context.Context, phoneNumber string, message string) error
+}
+
+// SMS is the sender used for one-time codes. main swaps it for a
+// FileSMSSender when SMS_OUTBOX_FILE is set.
+var SMS SMSSender = &LogSMSSender{}
+
+// LogSMSSender writes messages to the application log instead of sending
+// them. It is meant for local development only.
+type LogSMSSender struct{}
+
+func (s *LogSMSSender) Send(ctx context.Context, phoneNumber string, message string) error {
+	log.Printf("sms to %s: %s", phoneNumber, message)
+	return nil
+}
+
+// FileSMSSender appends every message as a JSON line to a file so tests and
+// local tooling can read the codes back.
+type FileSMSSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSMSSender(path string) *FileSMSSender {
+	return &FileSMSSender{path: path}
+}
+
+func (s *FileSMSSender) Send(ctx context.Context, phoneNumber string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(map[string]string{
+		"phone_number": phoneNumber,
+		"message":      message,
+		"sent_at":      time.Now().Format(time.RFC3339),
+	})
+}
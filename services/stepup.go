@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+const (
+	TransactionTokenTTL = time.Minute * 5
+
+	tokenUseAccess      = "access"
+	tokenUseTransaction = "transaction"
+)
+
+var ErrTransactionTokenInvalid = errors.New("transaction token is invalid")
+
+// TransactionScope is what a transaction token is issued for: a payment or a
+// transfer of at most Amount, and for a transfer the recipient.
+type TransactionScope struct {
+	Category    string
+	Amount      int64
+	RecipientID *uuid.UUID
+}
+
+// TransactionTokenClaim is a transaction token that has been spent. Release
+// gives it back when the transaction it authorized did not go through.
+type TransactionTokenClaim struct {
+	jti string
+}
+
+func (c *TransactionTokenClaim) Release(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	return TokenRevocations.Release(ctx, c.jti)
+}
+
+// StepUpRequired tells whether moving the amount needs the PIN to be
+// confirmed again: above STEP_UP_AMOUNT_THRESHOLD, or when the transfer goes
+// to a recipient the user has never completed a transfer to.
+func StepUpRequired(userId uuid.UUID, amount int64, recipientId *uuid.UUID) (bool, error) {
+	if amount > int64(getenvInt("STEP_UP_AMOUNT_THRESHOLD", 1000000)) {
+		return true, nil
+	}
+
+	if recipientId == nil {
+		return false, nil
+	}
+
+	db := database.DB
+	var count int64
+	err := db.Model(&Transaction{}).
+		Where("user_id = ? AND corresponding_user_id = ? AND type = ?", userId, *recipientId, "DEBIT").
+		Where("category = ? AND status = ?", CategoryTransfer, TransactionStatusSuccess).
+		Count(&count).Error
+	return count == 0, err
+}
+
+// IssueTransactionToken returns a short-lived, single-use token proving the
+// user has just confirmed their PIN in the given session for the transaction
+// described by scope.
+func IssueTransactionToken(userId uuid.UUID, sessionId uuid.UUID, scope TransactionScope) (string, time.Time, error) {
+	expiresAt := time.Now().Add(TransactionTokenTTL)
+	jwtClaims := jwt.MapClaims{
+		"iss":          tokenIssuer,
+		"iat":          time.Now().Unix(),
+		"exp":          expiresAt.Unix(),
+		"jti":          uuid.NewString(),
+		"sid":          sessionId.String(),
+		"user_id":      userId.String(),
+		"token_use":    tokenUseTransaction,
+		"category":     scope.Category,
+		"amount":       scope.Amount,
+		"recipient_id": scopeRecipient(scope),
+	}
+
+	token, err := SigningKeys.Sign(jwtClaims)
+	return token, expiresAt, err
+}
+
+// ConsumeTransactionToken checks that the token was issued to this user and
+// session for a transaction like the one in scope, with an amount up to the
+// one confirmed, and burns it so it cannot authorize a second transaction.
+// The caller releases the returned claim if the transaction then fails.
+func ConsumeTransactionToken(ctx context.Context, transactionToken string, userId uuid.UUID, sessionId uuid.UUID, scope TransactionScope) (*TransactionTokenClaim, error) {
+	claims, err := SigningKeys.Parse(transactionToken)
+	if err != nil {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	if claims["token_use"] != tokenUseTransaction || claims["user_id"] != userId.String() || claims["sid"] != sessionId.String() {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	amount, _ := claims["amount"].(float64)
+	if claims["category"] != scope.Category || claims["recipient_id"] != scopeRecipient(scope) || scope.Amount > int64(amount) {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	jti, _ := claims["jti"].(string)
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || jti == "" {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	revoked, err := TokenRevocations.IsRevoked(ctx, jti, userId.String(), issuedAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	// claiming is atomic, so of two requests racing with one token only the
+	// first gets through
+	claimed, err := TokenRevocations.Claim(ctx, jti, expiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrTransactionTokenInvalid
+	}
+
+	return &TransactionTokenClaim{jti: jti}, nil
+}
+
+func scopeRecipient(scope TransactionScope) string {
+	if scope.RecipientID == nil {
+		return ""
+	}
+	return scope.RecipientID.String()
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenTTL  = time.Minute * 15
+	RefreshTokenTTL = time.Hour * 24 * 30
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token is expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+type RefreshToken entity.RefreshToken
+
+func IssueAccessToken(user *User, sessionId uuid.UUID) (string, error) {
+	jwtClaims := jwt.MapClaims{
+		"iss":       tokenIssuer,
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(AccessTokenTTL).Unix(),
+		"jti":       uuid.NewString(),
+		"sid":       sessionId.String(),
+		"user_id":   user.ID.String(),
+		"role":      user.Role,
+		"token_use": tokenUseAccess,
+	}
+
+	return SigningKeys.Sign(jwtClaims)
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token.
+func ParseAccessToken(accessToken string) (jwt.MapClaims, error) {
+	claims, err := SigningKeys.Parse(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// transaction tokens are signed with the same keys
+	if claims["token_use"] != tokenUseAccess {
+		return nil, errors.New("token is not an access token")
+	}
+
+	return claims, nil
+}
+
+// IssueRefreshToken creates a new opaque refresh token in the given family.
+// Only the SHA-256 hash of the token is stored; the plain token is returned
+// once and must be handed to the client.
+func IssueRefreshToken(userId uuid.UUID, familyId uuid.UUID) (string, error) {
+	_, plain, err := issueRefreshTokenWithDb(database.DB, userId, familyId)
+	return plain, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family and returns the new token's record along with its plain value.
+// Presenting a token that was already rotated or revoked is treated as theft
+// and revokes the whole family.
+func RotateRefreshToken(plainToken string) (*RefreshToken, string, error) {
+	db := database.DB
+	var current RefreshToken
+	err := db.First(&current, &RefreshToken{TokenHash: hashToken(plainToken)}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if current.RevokedAt != nil {
+		if err := RevokeRefreshTokenFamily(current.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return nil, "", ErrRefreshTokenExpired
+	}
+
+	var issued *RefreshToken
+	newToken := ""
+	reused := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", current.ID).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// another request rotated the same token first
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		token, plain, err := issueRefreshTokenWithDb(tx, current.UserID, current.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		issued = token
+		newToken = plain
+		return tx.Model(&RefreshToken{}).
+			Where("id = ?", current.ID).
+			Update("replaced_by_id", issued.ID).Error
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		if err := RevokeRefreshTokenFamily(current.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	return issued, newToken, nil
+}
+
+// RevokeRefreshToken revokes the family of the given refresh token. Unknown
+// tokens are ignored so logout stays idempotent.
+func RevokeRefreshToken(plainToken string) error {
+	db := database.DB
+	var current RefreshToken
+	err := db.First(&current, &RefreshToken{TokenHash: hashToken(plainToken)}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return RevokeRefreshTokenFamily(current.FamilyID)
+}
+
+func RevokeRefreshTokenFamily(familyId uuid.UUID) error {
+	db := database.DB
+	return db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func issueRefreshTokenWithDb(db *gorm.DB, userId uuid.UUID, familyId uuid.UUID) (*RefreshToken, string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	refreshToken := &RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userId,
+		FamilyID:  familyId,
+		TokenHash: hashToken(plain),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Create(refreshToken).Error; err != nil {
+		return nil, "", err
+	}
+
+	return refreshToken, plain, nil
+}
+
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -8,21 +8,35 @@ import (
 	"github.com/google/uuid"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/validation"
 	"gorm.io/gorm"
 )
 
 type Transaction entity.Transaction
 
+var ErrInsufficientBalance = errors.New("balance is not enough")
+
+// NewTransactionRequest describes one leg of a transaction. Type is only
+// read by CreateMultipleTransactions, which checks it itself.
 type NewTransactionRequest struct {
 	UserID              uuid.UUID      `json:"user_id" validate:"required"`
-	Type                sql.NullString `json:"type" validate:"required"`
-	Amount              int64          `json:"amount" validate:"required"`
-	Remarks             string         `json:"remarks"`
+	Type                sql.NullString `json:"type"`
+	Amount              int64          `json:"amount" validate:"required,gt=0"`
+	Remarks             string         `json:"remarks" validate:"max=255"`
 	Category            string         `json:"category"`
+	SubCategory         string         `json:"sub_category"`
+	Channel             string         `json:"channel"`
 	CorrespondingUserID uuid.UUID      `json:"corresponding_user_id"`
 }
 
 func CreateDebitTransaction(targetUserId uuid.UUID, request NewTransactionRequest) (*Transaction, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+	if err := checkCategory(request.Category, request.SubCategory); err != nil {
+		return nil, err
+	}
+
 	db := database.DB
 	transaction := &Transaction{}
 
@@ -33,8 +47,15 @@ func CreateDebitTransaction(targetUserId uuid.UUID, request NewTransactionReques
 			return err
 		}
 
+		if err := checkCanSend(&user); err != nil {
+			return err
+		}
+		if err := checkTransactionLimits(tx, targetUserId, "DEBIT", request.Category, request.Amount); err != nil {
+			return err
+		}
+
 		if user.Balance < request.Amount {
-			return errors.New("balance is not enough")
+			return ErrInsufficientBalance
 		}
 
 		transaction = &Transaction{
@@ -43,7 +64,10 @@ func CreateDebitTransaction(targetUserId uuid.UUID, request NewTransactionReques
 			Type:          "DEBIT",
 			Amount:        request.Amount,
 			Remarks:       request.Remarks,
-			Status:        "PENDING",
+			Category:      request.Category,
+			SubCategory:   request.SubCategory,
+			Channel:       request.Channel,
+			Status:        TransactionStatusPending,
 			BalanceBefore: user.Balance,
 			BalanceAfter:  user.Balance - request.Amount,
 			CreatedAt:     time.Now(),
@@ -53,12 +77,19 @@ func CreateDebitTransaction(targetUserId uuid.UUID, request NewTransactionReques
 			return err
 		}
 
-		user.Balance = transaction.BalanceAfter
-		if err := tx.Save(&user).Error; err != nil {
+		if err := recordTransactionStatus(tx, transaction, "", "created"); err != nil {
 			return err
 		}
 
-		return nil
+		if err := postWalletJournal(tx, transaction, counterAccountCode(transaction.Category)); err != nil {
+			return err
+		}
+
+		if err := chargeFee(tx, transaction, request.Channel); err != nil {
+			return err
+		}
+
+		return transitionTransactionStatus(tx, transaction, TransactionStatusSuccess, "balance updated")
 	})
 
 	if err != nil {
@@ -69,6 +100,13 @@ func CreateDebitTransaction(targetUserId uuid.UUID, request NewTransactionReques
 }
 
 func CreateCreditTransaction(targetUserId uuid.UUID, request NewTransactionRequest) (*Transaction, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+	if err := checkCategory(request.Category, request.SubCategory); err != nil {
+		return nil, err
+	}
+
 	db := database.DB
 	transaction := &Transaction{}
 
@@ -79,13 +117,26 @@ func CreateCreditTransaction(targetUserId uuid.UUID, request NewTransactionReque
 			return err
 		}
 
+		if err := checkCanReceive(&user); err != nil {
+			return err
+		}
+		if err := checkTransactionLimits(tx, targetUserId, "CREDIT", request.Category, request.Amount); err != nil {
+			return err
+		}
+		if err := checkKycCaps(tx, &user, request.Amount); err != nil {
+			return err
+		}
+
 		transaction = &Transaction{
 			ID:            uuid.New(),
 			UserID:        targetUserId,
 			Type:          "CREDIT",
 			Amount:        request.Amount,
 			Remarks:       request.Remarks,
-			Status:        "PENDING",
+			Category:      request.Category,
+			SubCategory:   request.SubCategory,
+			Channel:       request.Channel,
+			Status:        TransactionStatusPending,
 			BalanceBefore: user.Balance,
 			BalanceAfter:  user.Balance + request.Amount,
 			CreatedAt:     time.Now(),
@@ -95,12 +146,19 @@ func CreateCreditTransaction(targetUserId uuid.UUID, request NewTransactionReque
 			return err
 		}
 
-		user.Balance = transaction.BalanceAfter
-		if err := tx.Save(&user).Error; err != nil {
+		if err := recordTransactionStatus(tx, transaction, "", "created"); err != nil {
 			return err
 		}
 
-		return nil
+		if err := postWalletJournal(tx, transaction, counterAccountCode(transaction.Category)); err != nil {
+			return err
+		}
+
+		if err := chargeFee(tx, transaction, request.Channel); err != nil {
+			return err
+		}
+
+		return transitionTransactionStatus(tx, transaction, TransactionStatusSuccess, "balance updated")
 	})
 
 	if err != nil {
@@ -111,6 +169,13 @@ func CreateCreditTransaction(targetUserId uuid.UUID, request NewTransactionReque
 }
 
 func CreateDebitTransactionWithDbTransaction(targetUserId uuid.UUID, request NewTransactionRequest, tx *gorm.DB) (*Transaction, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+	if err := checkCategory(request.Category, request.SubCategory); err != nil {
+		return nil, err
+	}
+
 	transaction := &Transaction{}
 	var user User
 	err := tx.First(&user, &User{ID: targetUserId}).Error
@@ -118,8 +183,15 @@ func CreateDebitTransactionWithDbTransaction(targetUserId uuid.UUID, request New
 		return nil, err
 	}
 
+	if err := checkCanSend(&user); err != nil {
+		return nil, err
+	}
+	if err := checkTransactionLimits(tx, targetUserId, "DEBIT", request.Category, request.Amount); err != nil {
+		return nil, err
+	}
+
 	if user.Balance < request.Amount {
-		return nil, errors.New("balance is not enough")
+		return nil, ErrInsufficientBalance
 	}
 
 	transaction = &Transaction{
@@ -128,7 +200,10 @@ func CreateDebitTransactionWithDbTransaction(targetUserId uuid.UUID, request New
 		Type:                "DEBIT",
 		Amount:              request.Amount,
 		Remarks:             request.Remarks,
-		Status:              "PENDING",
+		Category:            request.Category,
+		SubCategory:         request.SubCategory,
+		Channel:             request.Channel,
+		Status:              TransactionStatusPending,
 		BalanceBefore:       user.Balance,
 		BalanceAfter:        user.Balance - request.Amount,
 		CreatedAt:           time.Now(),
@@ -139,8 +214,11 @@ func CreateDebitTransactionWithDbTransaction(targetUserId uuid.UUID, request New
 		return nil, err
 	}
 
-	user.Balance = transaction.BalanceAfter
-	if err := tx.Save(&user).Error; err != nil {
+	if err := recordTransactionStatus(tx, transaction, "", "created"); err != nil {
+		return nil, err
+	}
+
+	if err := postWalletJournal(tx, transaction, counterAccountCode(transaction.Category)); err != nil {
 		return nil, err
 	}
 
@@ -148,6 +226,13 @@ func CreateDebitTransactionWithDbTransaction(targetUserId uuid.UUID, request New
 }
 
 func CreateCreditTransactionWithDbTransaction(targetUserId uuid.UUID, request NewTransactionRequest, tx *gorm.DB) (*Transaction, error) {
+	if err := validation.Struct(request); err != nil {
+		return nil, err
+	}
+	if err := checkCategory(request.Category, request.SubCategory); err != nil {
+		return nil, err
+	}
+
 	transaction := &Transaction{}
 	var user User
 	err := tx.First(&user, &User{ID: targetUserId}).Error
@@ -155,13 +240,26 @@ func CreateCreditTransactionWithDbTransaction(targetUserId uuid.UUID, request Ne
 		return nil, err
 	}
 
+	if err := checkCanReceive(&user); err != nil {
+		return nil, err
+	}
+	if err := checkTransactionLimits(tx, targetUserId, "CREDIT", request.Category, request.Amount); err != nil {
+		return nil, err
+	}
+	if err := checkKycCaps(tx, &user, request.Amount); err != nil {
+		return nil, err
+	}
+
 	transaction = &Transaction{
 		ID:                  uuid.New(),
 		UserID:              targetUserId,
 		Type:                "CREDIT",
 		Amount:              request.Amount,
 		Remarks:             request.Remarks,
-		Status:              "PENDING",
+		Category:            request.Category,
+		SubCategory:         request.SubCategory,
+		Channel:             request.Channel,
+		Status:              TransactionStatusPending,
 		BalanceBefore:       user.Balance,
 		BalanceAfter:        user.Balance + request.Amount,
 		CreatedAt:           time.Now(),
@@ -172,8 +270,11 @@ func CreateCreditTransactionWithDbTransaction(targetUserId uuid.UUID, request Ne
 		return nil, err
 	}
 
-	user.Balance = transaction.BalanceAfter
-	if err := tx.Save(&user).Error; err != nil {
+	if err := recordTransactionStatus(tx, transaction, "", "created"); err != nil {
+		return nil, err
+	}
+
+	if err := postWalletJournal(tx, transaction, counterAccountCode(transaction.Category)); err != nil {
 		return nil, err
 	}
 
@@ -201,32 +302,63 @@ func CreateMultipleTransactions(requests []NewTransactionRequest) ([]*Transactio
 	transactions := []*Transaction{}
 
 	err := db.Transaction(func(tx *gorm.DB) error {
-		for i := 0; i < len(requests); i++ {
-			request := requests[i]
-			if !request.Type.Valid {
-				return errors.New("type is required")
-			}
+		var err error
+		transactions, err = CreateMultipleTransactionsWithDbTransaction(requests, tx)
+		return err
+	})
 
-			if request.Type.String == "DEBIT" {
-				transaction, err := CreateDebitTransactionWithDbTransaction(request.UserID, request, tx)
-				if err != nil {
-					return err
-				}
-				transactions = append(transactions, transaction)
-			} else if request.Type.String == "CREDIT" {
-				transaction, err := CreateCreditTransactionWithDbTransaction(request.UserID, request, tx)
-				if err != nil {
-					return err
-				}
-				transactions = append(transactions, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// CreateMultipleTransactionsWithDbTransaction books the legs in tx, so the
+// caller can commit them together with its own changes.
+func CreateMultipleTransactionsWithDbTransaction(requests []NewTransactionRequest, tx *gorm.DB) ([]*Transaction, error) {
+	transactions := []*Transaction{}
+
+	for i := 0; i < len(requests); i++ {
+		request := requests[i]
+		if !request.Type.Valid {
+			return nil, errors.New("type is required")
+		}
+
+		if request.Type.String == "DEBIT" {
+			transaction, err := CreateDebitTransactionWithDbTransaction(request.UserID, request, tx)
+			if err != nil {
+				return nil, err
 			}
+			transactions = append(transactions, transaction)
+		} else if request.Type.String == "CREDIT" {
+			transaction, err := CreateCreditTransactionWithDbTransaction(request.UserID, request, tx)
+			if err != nil {
+				return nil, err
+			}
+			transactions = append(transactions, transaction)
 		}
+	}
 
-		return nil
-	})
+	// every leg has been booked, so they all settle together under one
+	// shared reference
+	referenceId := uuid.New()
+	for i, transaction := range transactions {
+		transaction.ReferenceID = &referenceId
+		if err := tx.Model(transaction).Update("reference_id", referenceId).Error; err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return nil, err
+		// the paying side bears the fee
+		if transaction.Type == "DEBIT" {
+			if err := chargeFee(tx, transaction, requests[i].Channel); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := transitionTransactionStatus(tx, transaction, TransactionStatusSuccess, "balance updated"); err != nil {
+			return nil, err
+		}
 	}
 
 	return transactions, nil
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+type TransactionStatusHistory entity.TransactionStatusHistory
+
+// GetUserTransaction returns one of the user's transactions together with its
+// status history. Transactions of other users are reported as not found.
+func GetUserTransaction(userId uuid.UUID, transactionId uuid.UUID) (*Transaction, []TransactionStatusHistory, error) {
+	db := database.DB
+	var transaction Transaction
+	err := db.Preload("User").
+		Preload("CorrespondingUser").
+		First(&transaction, &Transaction{ID: transactionId, UserID: userId}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history := []TransactionStatusHistory{}
+	err = db.Where("transaction_id = ?", transaction.ID).
+		Order("created_at asc").
+		Find(&history).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &transaction, history, nil
+}
+
+// recordTransactionStatus appends the transaction's current status to its
+// history.
+func recordTransactionStatus(tx *gorm.DB, transaction *Transaction, fromStatus string, reason string) error {
+	return tx.Create(&TransactionStatusHistory{
+		ID:            uuid.New(),
+		TransactionID: transaction.ID,
+		FromStatus:    fromStatus,
+		ToStatus:      transaction.Status,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
+	}).Error
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+const (
+	DefaultTransactionPageSize = 20
+	MaxTransactionPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("cursor is invalid")
+
+type TransactionFilter struct {
+	Type        string
+	Category    string
+	SubCategory string
+	Status      string
+	From        *time.Time
+	To          *time.Time
+	MinAmount   *int64
+	MaxAmount   *int64
+}
+
+type TransactionPage struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+// ListTransactions returns the user's transactions, newest first, one page at
+// a time. The cursor is opaque to clients; pass the NextCursor of the
+// previous page to continue, or an empty string to start from the top.
+func ListTransactions(userId uuid.UUID, filter TransactionFilter, cursor string, limit int) (*TransactionPage, error) {
+	if limit <= 0 {
+		limit = DefaultTransactionPageSize
+	}
+	if limit > MaxTransactionPageSize {
+		limit = MaxTransactionPageSize
+	}
+
+	db := database.DB
+	query := db.Preload("CorrespondingUser").Where("user_id = ?", userId)
+
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Category != "" {
+		query = query.Where("category = ?", filter.Category)
+	}
+	if filter.SubCategory != "" {
+		query = query.Where("sub_category = ?", filter.SubCategory)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where("amount <= ?", *filter.MaxAmount)
+	}
+
+	if cursor != "" {
+		createdAt, id, err := decodeTransactionCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	// fetch one extra row to know whether there is a next page
+	transactions := []Transaction{}
+	err := query.Order("created_at desc").Order("id desc").Limit(limit + 1).Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = encodeTransactionCursor(last.CreatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+func encodeTransactionCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTransactionCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	return createdAt, id, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TransactionStatusPending   = "PENDING"
+	TransactionStatusSuccess   = "SUCCESS"
+	TransactionStatusFailed    = "FAILED"
+	TransactionStatusReversed  = "REVERSED"
+	TransactionStatusCancelled = "CANCELLED"
+)
+
+// transactionStatusTransitions lists the statuses each status may move to.
+// A transaction starts PENDING; FAILED, REVERSED and CANCELLED are final.
+var transactionStatusTransitions = map[string][]string{
+	TransactionStatusPending:   {TransactionStatusSuccess, TransactionStatusFailed, TransactionStatusCancelled},
+	TransactionStatusSuccess:   {TransactionStatusReversed},
+	TransactionStatusFailed:    {},
+	TransactionStatusReversed:  {},
+	TransactionStatusCancelled: {},
+}
+
+var (
+	ErrInvalidTransactionStatusTransition = errors.New("transaction status transition is not allowed")
+	ErrTransactionStatusChanged           = errors.New("transaction status was changed concurrently")
+)
+
+// transitionTransactionStatus moves the transaction to a new status and
+// records the change in its history. The update only applies while the row
+// still has the status the caller read, so two concurrent transitions cannot
+// both succeed; the loser gets ErrTransactionStatusChanged.
+func transitionTransactionStatus(tx *gorm.DB, transaction *Transaction, status string, reason string) error {
+	if !canTransitionTransactionStatus(transaction.Status, status) {
+		return ErrInvalidTransactionStatusTransition
+	}
+
+	fromStatus := transaction.Status
+	result := tx.Model(&Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, fromStatus).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTransactionStatusChanged
+	}
+
+	transaction.Status = status
+	return recordTransactionStatus(tx, transaction, fromStatus, reason)
+}
+
+func canTransitionTransactionStatus(from string, to string) bool {
+	for _, allowed := range transactionStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+)
+
+// openTestDB points the package at a fresh SQLite file, opened the same way
+// as the real database so writers are serialized like in production.
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+func TestCreateDebitTransactionConcurrentlyNeverOverdraws(t *testing.T) {
+	openTestDB(t)
+
+	const (
+		funded     = 100000
+		amount     = 30000
+		requests   = 10
+		affordable = funded / amount
+	)
+
+	user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateCreditTransaction(user.ID, NewTransactionRequest{
+		UserID:   user.ID,
+		Amount:   funded,
+		Category: CategoryTopUp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := CreateDebitTransaction(user.ID, NewTransactionRequest{
+				UserID:   user.ID,
+				Amount:   amount,
+				Category: CategoryPayment,
+			})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrInsufficientBalance):
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != affordable {
+		t.Errorf("%d debits succeeded, want %d", succeeded, affordable)
+	}
+
+	wallet, err := GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(funded - affordable*amount); wallet.Balance != want {
+		t.Errorf("balance is %d, want %d", wallet.Balance, want)
+	}
+
+	report, err := VerifyLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Balanced {
+		t.Errorf("ledger drifted: %+v", report)
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"gorm.io/gorm"
+)
+
+const maxTransferRetryDelay = time.Minute
+
+// SubmitTransfer accepts a transfer for asynchronous processing. The sender
+// is debited right away so the money cannot be spent twice, and the debit
+// leg stays PENDING until the worker has credited the recipient.
+func SubmitTransfer(ctx context.Context, senderId uuid.UUID, request NewTransactionRequest) (*Transaction, error) {
+	db := database.DB
+	var transaction *Transaction
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		recipient, err := checkRecipient(tx, senderId, request.CorrespondingUserID)
+		if err != nil {
+			return err
+		}
+		if err := checkKycCaps(tx, recipient, request.Amount); err != nil {
+			return err
+		}
+
+		transaction, err = CreateDebitTransactionWithDbTransaction(senderId, request, tx)
+		if err != nil {
+			return err
+		}
+
+		// both legs of the transfer share the reference
+		referenceId := uuid.New()
+		transaction.ReferenceID = &referenceId
+		if err := tx.Model(transaction).Update("reference_id", referenceId).Error; err != nil {
+			return err
+		}
+
+		return chargeFee(tx, transaction, request.Channel)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the transfer is stored already; if the queue is unavailable it is
+	// picked up again by RequeuePendingTransfers on the next start
+	if err := TransferJobs.Enqueue(ctx, TransferJob{TransactionID: transaction.ID}); err != nil {
+		log.Printf("transfer %s: enqueue failed: %v", transaction.ID, err)
+	}
+
+	return transaction, nil
+}
+
+// ProcessTransfer credits the recipient of a pending transfer and marks it
+// SUCCESS. If the recipient can no longer receive money the sender is
+// refunded and the transfer is marked FAILED. Transfers that are not pending
+// any more are skipped, so a job may safely be delivered twice. Returned
+// errors are temporary and the job should be retried.
+func ProcessTransfer(transactionId uuid.UUID) error {
+	db := database.DB
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var debit Transaction
+		if err := tx.First(&debit, &Transaction{ID: transactionId}).Error; err != nil {
+			return err
+		}
+		if debit.Status != TransactionStatusPending {
+			return nil
+		}
+
+		var recipient User
+		err := tx.First(&recipient, &User{ID: *debit.CorrespondingUserID}).Error
+		if err == gorm.ErrRecordNotFound {
+			return failTransferWithDbTransaction(tx, &debit, "recipient not found")
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkCanReceive(&recipient); err != nil {
+			return failTransferWithDbTransaction(tx, &debit, err.Error())
+		}
+		// the recipient may have received other money since the transfer was accepted
+		err = checkKycCaps(tx, &recipient, debit.Amount)
+		if err == ErrKycBalanceCapExceeded || err == ErrKycMonthlyInflowExceeded {
+			return failTransferWithDbTransaction(tx, &debit, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		// claim the transfer first so concurrent workers cannot settle it twice
+		if err := transitionTransactionStatus(tx, &debit, TransactionStatusSuccess, "transfer completed"); err != nil {
+			return err
+		}
+
+		credit := &Transaction{
+			ID:                  uuid.New(),
+			UserID:              recipient.ID,
+			Type:                "CREDIT",
+			Amount:              debit.Amount,
+			Remarks:             debit.Remarks,
+			Category:            debit.Category,
+			Channel:             debit.Channel,
+			Status:              TransactionStatusPending,
+			BalanceBefore:       recipient.Balance,
+			BalanceAfter:        recipient.Balance + debit.Amount,
+			CreatedAt:           time.Now(),
+			CorrespondingUserID: &debit.UserID,
+			ReferenceID:         debit.ReferenceID,
+		}
+		if err := tx.Create(credit).Error; err != nil {
+			return err
+		}
+		if err := recordTransactionStatus(tx, credit, "", "created"); err != nil {
+			return err
+		}
+
+		if err := postWalletJournal(tx, credit, LedgerAccountTransferClearing); err != nil {
+			return err
+		}
+
+		return transitionTransactionStatus(tx, credit, TransactionStatusSuccess, "balance updated")
+	})
+	if err == ErrTransactionStatusChanged {
+		// another worker settled it in the meantime
+		return nil
+	}
+	return err
+}
+
+// FailTransfer gives up on a pending transfer and refunds the sender.
+func FailTransfer(transactionId uuid.UUID, reason string) error {
+	db := database.DB
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var debit Transaction
+		if err := tx.First(&debit, &Transaction{ID: transactionId}).Error; err != nil {
+			return err
+		}
+		if debit.Status != TransactionStatusPending {
+			return nil
+		}
+
+		return failTransferWithDbTransaction(tx, &debit, reason)
+	})
+	if err == ErrTransactionStatusChanged {
+		return nil
+	}
+	return err
+}
+
+func failTransferWithDbTransaction(tx *gorm.DB, debit *Transaction, reason string) error {
+	if err := transitionTransactionStatus(tx, debit, TransactionStatusFailed, reason); err != nil {
+		return err
+	}
+
+	// hand the money held in clearing back to the sender
+	wallet, err := walletAccount(tx, debit.UserID)
+	if err != nil {
+		return err
+	}
+	clearing, err := systemAccount(tx, LedgerAccountTransferClearing)
+	if err != nil {
+		return err
+	}
+
+	_, err = postJournal(tx, "Transfer failed", []LedgerLine{
+		{AccountID: wallet.ID, Amount: debit.Amount, TransactionID: &debit.ID},
+		{AccountID: clearing.ID, Amount: -debit.Amount, TransactionID: &debit.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	return refundFee(tx, debit, debit.Amount, debit.ReferenceID, "transfer failed")
+}
+
+// RequeuePendingTransfers enqueues every transfer that is still pending, e.g.
+// after a restart lost the in-process queue.
+func RequeuePendingTransfers(ctx context.Context) error {
+	db := database.DB
+	transactionIds := []uuid.UUID{}
+	err := db.Model(&Transaction{}).
+		Where("type = ? AND status = ? AND reference_id IS NOT NULL", "DEBIT", TransactionStatusPending).
+		Pluck("id", &transactionIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, transactionId := range transactionIds {
+		if err := TransferJobs.Enqueue(ctx, TransferJob{TransactionID: transactionId}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunTransferWorker processes queued transfers until ctx is cancelled. A job
+// that fails is retried with an increasing delay, and after
+// TRANSFER_MAX_ATTEMPTS it goes to the dead-letter queue and the transfer is
+// failed.
+func RunTransferWorker(ctx context.Context) {
+	maxAttempts := getenvInt("TRANSFER_MAX_ATTEMPTS", 5)
+
+	for {
+		job, err := TransferJobs.Dequeue(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("transfer worker: dequeue failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		err = ProcessTransfer(job.TransactionID)
+		if err == nil {
+			continue
+		}
+
+		job.Attempts++
+		job.LastError = err.Error()
+		log.Printf("transfer %s: attempt %d failed: %v", job.TransactionID, job.Attempts, err)
+
+		if job.Attempts < maxAttempts {
+			retryJob := job
+			time.AfterFunc(transferRetryDelay(job.Attempts), func() {
+				if err := TransferJobs.Enqueue(ctx, retryJob); err != nil {
+					log.Printf("transfer %s: requeue failed: %v", retryJob.TransactionID, err)
+				}
+			})
+			continue
+		}
+
+		if err := TransferJobs.DeadLetter(ctx, job); err != nil {
+			log.Printf("transfer %s: dead letter failed: %v", job.TransactionID, err)
+		}
+		if err := FailTransfer(job.TransactionID, "processing failed: "+job.LastError); err != nil {
+			log.Printf("transfer %s: marking as failed: %v", job.TransactionID, err)
+		}
+	}
+}
+
+// transferRetryDelay doubles with every attempt: 1s, 2s, 4s... capped at a
+// minute.
+func transferRetryDelay(attempts int) time.Duration {
+	delay := time.Second << (attempts - 1)
+	if delay > maxTransferRetryDelay || delay <= 0 {
+		return maxTransferRetryDelay
+	}
+	return delay
+}
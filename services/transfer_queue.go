@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	transferQueueKey      = "transfer_queue"
+	transferDeadLetterKey = "transfer_queue:dead"
+)
+
+// TransferJob asks the worker to settle the pending transfer whose debit leg
+// is TransactionID.
+type TransferJob struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// TransferQueue hands accepted transfers to the worker. Jobs that keep
+// failing are moved to a dead-letter queue for an operator to look at.
+type TransferQueue interface {
+	Enqueue(ctx context.Context, job TransferJob) error
+	// Dequeue blocks until a job is available or ctx is done.
+	Dequeue(ctx context.Context) (TransferJob, error)
+	DeadLetter(ctx context.Context, job TransferJob) error
+}
+
+// TransferJobs is the queue used by SubmitTransfer and the worker. It is
+// in-process by default and swapped for Redis in main when REDIS_URL is set.
+var TransferJobs TransferQueue = NewMemoryTransferQueue(1024)
+
+type MemoryTransferQueue struct {
+	jobs chan TransferJob
+
+	mu          sync.Mutex
+	deadLetters []TransferJob
+}
+
+func NewMemoryTransferQueue(size int) *MemoryTransferQueue {
+	return &MemoryTransferQueue{jobs: make(chan TransferJob, size)}
+}
+
+func (q *MemoryTransferQueue) Enqueue(ctx context.Context, job TransferJob) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *MemoryTransferQueue) Dequeue(ctx context.Context) (TransferJob, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-ctx.Done():
+		return TransferJob{}, ctx.Err()
+	}
+}
+
+func (q *MemoryTransferQueue) DeadLetter(ctx context.Context, job TransferJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadLetters = append(q.deadLetters, job)
+	return nil
+}
+
+func (q *MemoryTransferQueue) DeadLetters() []TransferJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]TransferJob{}, q.deadLetters...)
+}
+
+// RedisTransferQueue keeps jobs as JSON in Redis lists so they survive a
+// restart and can be shared by several API instances.
+type RedisTransferQueue struct {
+	client *redis.Client
+}
+
+func NewRedisTransferQueue(client *redis.Client) *RedisTransferQueue {
+	return &RedisTransferQueue{client: client}
+}
+
+func (q *RedisTransferQueue) Enqueue(ctx context.Context, job TransferJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.LPush(ctx, transferQueueKey, payload).Err()
+}
+
+func (q *RedisTransferQueue) Dequeue(ctx context.Context) (TransferJob, error) {
+	for {
+		// wake up now and then so a cancelled ctx is noticed
+		result, err := q.client.BRPop(ctx, time.Second*5, transferQueueKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return TransferJob{}, err
+		}
+
+		var job TransferJob
+		if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+			return TransferJob{}, err
+		}
+		return job, nil
+	}
+}
+
+func (q *RedisTransferQueue) DeadLetter(ctx context.Context, job TransferJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.LPush(ctx, transferDeadLetterKey, payload).Err()
+}
@@ -1,16 +1,29 @@
 package services
 
 import (
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
 	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type User entity.User
 
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+)
+
+var (
+	ErrPinMismatch = errors.New("pin doesn't match")
+	ErrInvalidRole = errors.New("role is invalid")
+)
+
 func GetUserByID(id uuid.UUID) (*User, error) {
 	db := database.DB
 	found := User{}
@@ -42,6 +55,9 @@ func CreateUser(user *User) (*User, error) {
 		PhoneNumber: user.PhoneNumber,
 		Pin:         hashAndSalt([]byte(user.Pin)),
 		Address:     user.Address,
+		Role:        RoleCustomer,
+		Status:      UserStatusActive,
+		KycTier:     KycTierBasic,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -84,8 +100,17 @@ func UpdateUser(userRequest *User) (*User, error) {
 	if userRequest.Address != "" {
 		user.Address = userRequest.Address
 	}
+	if userRequest.Handle != nil {
+		handle := NormalizeHandle(*userRequest.Handle)
+		user.Handle = &handle
+	}
 
-	err = db.Save(&user).Error
+	// only write the profile fields so a concurrent balance change is kept;
+	// the unique index decides who gets a handle claimed by two users at once
+	err = db.Model(&user).Select("first_name", "last_name", "address", "handle").Updates(&user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, ErrHandleTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +118,70 @@ func UpdateUser(userRequest *User) (*User, error) {
 	return &user, nil
 }
 
+// UpdateUserPin stores a new PIN hash for the user. Callers are expected to
+// revoke the user's sessions afterwards.
+func UpdateUserPin(userId uuid.UUID, newPin string) error {
+	db := database.DB
+	result := db.Model(&User{}).
+		Where("id = ?", userId).
+		Update("pin", hashAndSalt([]byte(newPin)))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateUserRole changes the role of a user. Roles are carried in access
+// tokens, so callers should revoke the user's sessions afterwards.
+func UpdateUserRole(userId uuid.UUID, role string) (*User, error) {
+	if role != RoleCustomer && role != RoleSupport && role != RoleAdmin {
+		return nil, ErrInvalidRole
+	}
+
+	db := database.DB
+	var user User
+	if err := db.First(&user, &User{ID: userId}).Error; err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	if err := db.Model(&user).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// EnsureAdmin grants the admin role to the user with the given phone number.
+// It bootstraps the first admin from ADMIN_PHONE_NUMBER on startup.
+func EnsureAdmin(phoneNumber string) error {
+	db := database.DB
+	return db.Model(&User{}).
+		Where("phone_number = ?", phoneNumber).
+		Update("role", RoleAdmin).Error
+}
+
+// VerifyPin checks the user's PIN against the same lockout counters as
+// login, so guesses made through any PIN prompt count toward the lockout.
+func VerifyPin(user *User, pin string, ip string) error {
+	if err := CheckLoginAllowed(user.PhoneNumber, ip); err != nil {
+		return err
+	}
+
+	if err := CompareHash(user.Pin, pin); err != nil {
+		if err := RecordLoginFailure(user.PhoneNumber, ip); err != nil {
+			return err
+		}
+		return ErrPinMismatch
+	}
+
+	return ResetLoginFailures(user.PhoneNumber)
+}
+
 func CompareHash(hashedPin string, plainPin string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPin), []byte(plainPin))
 }
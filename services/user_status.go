@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/database"
+	"github.com/kiplikipli/technical-test-fm-tahap-2/entity"
+	"gorm.io/gorm"
+)
+
+const (
+	UserStatusActive    = "ACTIVE"
+	UserStatusFrozen    = "FROZEN"
+	UserStatusSuspended = "SUSPENDED"
+	UserStatusClosed    = "CLOSED"
+)
+
+// userStatusTransitions lists the statuses each status may move to. CLOSED
+// is final.
+var userStatusTransitions = map[string][]string{
+	UserStatusActive:    {UserStatusFrozen, UserStatusSuspended, UserStatusClosed},
+	UserStatusFrozen:    {UserStatusActive, UserStatusSuspended, UserStatusClosed},
+	UserStatusSuspended: {UserStatusActive, UserStatusFrozen, UserStatusClosed},
+	UserStatusClosed:    {},
+}
+
+var (
+	ErrInvalidUserStatus           = errors.New("status is invalid")
+	ErrInvalidUserStatusTransition = errors.New("status transition is not allowed")
+	ErrStatusReasonRequired        = errors.New("reason is required")
+	ErrAccountHasBalance           = errors.New("account still has a balance")
+	ErrAccountFrozen               = errors.New("account is frozen")
+	ErrAccountSuspended            = errors.New("account is suspended")
+	ErrAccountClosed               = errors.New("account is closed")
+)
+
+type UserStatusChange entity.UserStatusChange
+
+// ChangeUserStatus moves the user to a new status and records who did it and
+// why. Suspending or closing an account also logs it out everywhere.
+func ChangeUserStatus(ctx context.Context, userId uuid.UUID, status string, reason string, changedById uuid.UUID) (*User, error) {
+	if _, ok := userStatusTransitions[status]; !ok {
+		return nil, ErrInvalidUserStatus
+	}
+	if reason == "" {
+		return nil, ErrStatusReasonRequired
+	}
+
+	db := database.DB
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, &User{ID: userId}).Error; err != nil {
+			return err
+		}
+
+		if !canTransitionUserStatus(user.Status, status) {
+			return ErrInvalidUserStatusTransition
+		}
+
+		if status == UserStatusClosed && user.Balance != 0 {
+			return ErrAccountHasBalance
+		}
+
+		statusChange := &UserStatusChange{
+			ID:          uuid.New(),
+			UserID:      user.ID,
+			FromStatus:  user.Status,
+			ToStatus:    status,
+			Reason:      reason,
+			ChangedByID: changedById,
+			CreatedAt:   time.Now(),
+		}
+		if err := tx.Create(statusChange).Error; err != nil {
+			return err
+		}
+
+		user.Status = status
+		return tx.Model(&user).Update("status", status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if status == UserStatusSuspended || status == UserStatusClosed {
+		if err := RevokeAllUserSessions(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func GetUserStatusHistory(userId uuid.UUID) ([]UserStatusChange, error) {
+	db := database.DB
+	history := []UserStatusChange{}
+	err := db.Where("user_id = ?", userId).Order("created_at asc").Find(&history).Error
+	return history, err
+}
+
+// CheckCanLogin rejects suspended and closed accounts. Frozen accounts may
+// still log in to see their balance.
+func CheckCanLogin(user *User) error {
+	switch user.Status {
+	case UserStatusSuspended:
+		return ErrAccountSuspended
+	case UserStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// checkCanSend only lets active accounts move money out.
+func checkCanSend(user *User) error {
+	switch user.Status {
+	case UserStatusFrozen:
+		return ErrAccountFrozen
+	case UserStatusSuspended:
+		return ErrAccountSuspended
+	case UserStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// checkCanReceive rejects credits to closed accounts only; frozen and
+// suspended accounts keep receiving so incoming money is not bounced.
+func checkCanReceive(user *User) error {
+	if user.Status == UserStatusClosed {
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+func canTransitionUserStatus(from string, to string) bool {
+	for _, allowed := range userStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestUpdateUserConcurrentHandleClaims(t *testing.T) {
+	openTestDB(t)
+
+	const claims = 5
+	users := []*User{}
+	for i := 0; i < claims; i++ {
+		user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: fmt.Sprintf("08120000000%d", i), Pin: "123456"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, claims)
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user *User) {
+			defer wg.Done()
+			<-start
+			handle := "@Alice"
+			_, err := UpdateUser(&User{ID: user.ID, Handle: &handle})
+			errs <- err
+		}(user)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrHandleTaken:
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d users got the handle, want 1", succeeded)
+	}
+}
+
+func TestUpdateUserKeepsOwnHandle(t *testing.T) {
+	openTestDB(t)
+
+	user, err := CreateUser(&User{FirstName: "Test", PhoneNumber: "081200000001", Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		handle := "alice"
+		if _, err := UpdateUser(&User{ID: user.ID, Handle: &handle}); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	pinPattern   = regexp.MustCompile(`^[0-9]{6}$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9]{9,15}$`)
+	// handles start with a letter so they can't be mistaken for a phone number
+	handlePattern = regexp.MustCompile(`^@?[a-zA-Z][a-zA-Z0-9_]{2,29}$`)
+)
+
+// Validate evaluates the `validate` struct tags. Besides the built-in rules
+// it knows "pin" (exactly 6 digits), "phone" (9-15 digits with an optional
+// leading +) and "handle" (3-30 letters, digits or underscores starting with
+// a letter, optionally prefixed with @).
+var Validate = newValidator()
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func Struct(s interface{}) error {
+	return Validate.Struct(s)
+}
+
+// IsPhoneNumber reports whether s passes the "phone" rule.
+func IsPhoneNumber(s string) bool {
+	return phonePattern.MatchString(s)
+}
+
+// FieldErrors turns a validation error into one readable message per field.
+// It returns nil for errors that did not come from the validator.
+func FieldErrors(err error) []FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+
+	fieldErrors := []FieldError{}
+	for _, fieldError := range validationErrors {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   fieldError.Field(),
+			Message: message(fieldError),
+		})
+	}
+	return fieldErrors
+}
+
+func message(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required", "required_if":
+		return "is required"
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fieldError.Param())
+	case "gte":
+		return fmt.Sprintf("must be at least %s", fieldError.Param())
+	case "max":
+		if fieldError.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters", fieldError.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fieldError.Param())
+	case "len":
+		return fmt.Sprintf("must be exactly %s characters", fieldError.Param())
+	case "numeric":
+		return "must only contain digits"
+	case "datetime":
+		return fmt.Sprintf("must be a date formatted as %s", fieldError.Param())
+	case "uuid":
+		return "must be a valid UUID"
+	case "ip":
+		return "must be a valid IP address"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fieldError.Param(), " ", ", "))
+	case "nefield":
+		return "must be different from the current value"
+	case "pin":
+		return "must be exactly 6 digits"
+	case "phone":
+		return "must be a valid phone number"
+	case "handle":
+		return "must be 3-30 letters, digits or underscores and start with a letter"
+	}
+	return fmt.Sprintf("failed on the %s rule", fieldError.Tag())
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// report fields by their JSON names
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	v.RegisterValidation("pin", func(fl validator.FieldLevel) bool {
+		return pinPattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("phone", func(fl validator.FieldLevel) bool {
+		return IsPhoneNumber(fl.Field().String())
+	})
+	v.RegisterValidation("handle", func(fl validator.FieldLevel) bool {
+		return handlePattern.MatchString(fl.Field().String())
+	})
+
+	return v
+}